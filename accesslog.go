@@ -0,0 +1,177 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bww/go-util/v1/ext"
+)
+
+type AccessLogFormat int
+
+const (
+	AccessLogSlog     AccessLogFormat = iota // attributes on the request logger
+	AccessLogCombined                        // Apache combined log format
+	AccessLogLogfmt                          // logfmt key/value pairs
+)
+
+// Access log configuration
+type AccessLogConfig struct {
+	Format        AccessLogFormat
+	Writer        io.Writer // the destination for text formats; the default is stdout
+	Suppress      []string  // route patterns for which access is not logged, e.g., health checks
+	SampleSuccess float64   // the fraction of 2xx responses which are logged, in (0, 1); otherwise all are logged
+}
+
+// An access log entry, which describes a request after it's been handled
+type accessEntry struct {
+	Time      time.Time
+	Method    string
+	Resource  string
+	Proto     string
+	Route     string
+	Status    int
+	Bytes     int64
+	Duration  time.Duration
+	Remote    string
+	User      string
+	Referer   string
+	UserAgent string
+	RequestID string
+}
+
+type accessLogger struct {
+	sync.Mutex
+	format   AccessLogFormat
+	writer   io.Writer
+	suppress map[string]struct{}
+	sample   float64
+}
+
+func newAccessLogger(conf AccessLogConfig) *accessLogger {
+	var suppress map[string]struct{}
+	if len(conf.Suppress) > 0 {
+		suppress = make(map[string]struct{})
+		for _, e := range conf.Suppress {
+			suppress[e] = struct{}{}
+		}
+	}
+	var writer io.Writer
+	if conf.Writer != nil {
+		writer = conf.Writer
+	} else {
+		writer = os.Stdout
+	}
+	return &accessLogger{
+		format:   conf.Format,
+		writer:   writer,
+		suppress: suppress,
+		sample:   conf.SampleSuccess,
+	}
+}
+
+// Determine if an entry should be logged
+func (l *accessLogger) include(e accessEntry) bool {
+	if _, ok := l.suppress[e.Route]; ok && e.Route != "" {
+		return false
+	}
+	if e.Status >= 200 && e.Status < 300 && l.sample > 0 && l.sample < 1 {
+		return rand.Float64() < l.sample
+	}
+	return true
+}
+
+// Log an entry; the provided logger is used for the slog format and is
+// expected to already carry the request attributes
+func (l *accessLogger) Log(log *slog.Logger, e accessEntry) {
+	if !l.include(e) {
+		return
+	}
+	switch l.format {
+	case AccessLogCombined:
+		l.write(formatCombined(e))
+	case AccessLogLogfmt:
+		l.write(formatLogfmt(e))
+	default:
+		log.Info("Request",
+			"route", e.Route,
+			"status", e.Status,
+			"bytes", e.Bytes,
+			"duration", e.Duration,
+			"remote", e.Remote,
+			"user_agent", e.UserAgent,
+		)
+	}
+}
+
+func (l *accessLogger) write(s string) {
+	l.Lock()
+	defer l.Unlock()
+	io.WriteString(l.writer, s)
+}
+
+// Format an entry in the Apache combined log format
+func formatCombined(e accessEntry) string {
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s\n",
+		orDash(e.Remote),
+		orDash(e.User),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(fmt.Sprintf("%s %s %s", e.Method, e.Resource, e.Proto)),
+		e.Status,
+		ext.Choose(e.Bytes > 0, strconv.FormatInt(e.Bytes, 10), "-"),
+		strconv.Quote(orDash(e.Referer)),
+		strconv.Quote(orDash(e.UserAgent)),
+	)
+}
+
+// Format an entry as logfmt
+func formatLogfmt(e accessEntry) string {
+	sb := &strings.Builder{}
+	kv := func(k, v string) {
+		if sb.Len() > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(logfmtValue(v))
+	}
+	kv("time", e.Time.Format(time.RFC3339Nano))
+	kv("method", e.Method)
+	kv("resource", e.Resource)
+	kv("route", e.Route)
+	kv("status", strconv.Itoa(e.Status))
+	kv("bytes", strconv.FormatInt(e.Bytes, 10))
+	kv("duration", e.Duration.String())
+	kv("remote", e.Remote)
+	kv("user_agent", e.UserAgent)
+	kv("request_id", e.RequestID)
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// Quote a logfmt value if necessary
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	for _, c := range v {
+		if c <= ' ' || c == '"' || c == '=' || c == '\\' || !strconv.IsPrint(c) {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
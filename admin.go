@@ -0,0 +1,240 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bww/go-router/v2"
+	"github.com/bww/go-util/v1/ext"
+)
+
+// The default time permitted for a health check to complete
+const defaultHealthTimeout = 5 * time.Second
+
+// A health check reports an error if the component it checks is unhealthy.
+// Checks must respect cancellation of the provided context.
+type HealthCheck func(context.Context) error
+
+type healthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// The result of a health check
+type HealthResult struct {
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// A readiness report, which aggregates the results of health checks
+type HealthReport struct {
+	Status   string                  `json:"status"`
+	Draining bool                    `json:"draining,omitempty"`
+	Checks   map[string]HealthResult `json:"checks,omitempty"`
+}
+
+const (
+	healthOK      = "ok"
+	healthFailing = "failing"
+)
+
+type health struct {
+	sync.RWMutex
+	checks   []healthCheck
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+// Register a health check which is consulted by the readiness endpoint
+func (s *Service) AddHealthCheck(name string, check HealthCheck) {
+	s.health.Lock()
+	defer s.health.Unlock()
+	s.health.checks = append(s.health.checks, healthCheck{name, check})
+}
+
+// Mark the service as draining, or not. A draining service is shutting down
+// and reports that it is not ready, regardless of its health checks.
+func (s *Service) SetDraining(on bool) {
+	s.health.draining.Store(on)
+}
+
+// Run all health checks in parallel and produce a report. Each check is
+// permitted the configured timeout to complete. If the service is draining
+// the checks are not run and the report is failing.
+func (s *Service) CheckHealth(cxt context.Context) HealthReport {
+	if s.health.draining.Load() {
+		return HealthReport{Status: healthFailing, Draining: true}
+	}
+
+	s.health.RLock()
+	checks := append([]healthCheck(nil), s.health.checks...)
+	timeout := s.health.timeout
+	s.health.RUnlock()
+
+	res := make([]HealthResult, len(checks))
+	var wg sync.WaitGroup
+	for i, e := range checks {
+		wg.Add(1)
+		go func(i int, e healthCheck) {
+			defer wg.Done()
+			res[i] = runHealthCheck(cxt, e.check, timeout)
+		}(i, e)
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: healthOK}
+	if len(checks) > 0 {
+		report.Checks = make(map[string]HealthResult)
+	}
+	for i, e := range checks {
+		report.Checks[e.name] = res[i]
+		if res[i].Status != healthOK {
+			report.Status = healthFailing
+		}
+	}
+	return report
+}
+
+func runHealthCheck(cxt context.Context, check HealthCheck, timeout time.Duration) HealthResult {
+	cxt, cancel := context.WithTimeout(cxt, timeout)
+	defer cancel()
+	start := time.Now()
+
+	errs := make(chan error, 1)
+	go func() {
+		// the check runs in its own goroutine, so a panic must be recovered
+		// here; it is reported as a failure of the check
+		defer func() {
+			if r := recover(); r != nil {
+				errs <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errs <- check(cxt)
+	}()
+	var err error
+	select {
+	case err = <-errs:
+	case <-cxt.Done():
+		err = cxt.Err() // the check did not respect the deadline; don't wait for it
+	}
+	if err != nil {
+		return HealthResult{Status: healthFailing, Error: err.Error(), Duration: time.Since(start)}
+	}
+	return HealthResult{Status: healthOK, Duration: time.Since(start)}
+}
+
+// Mount administrative endpoints on the service under the provided prefix:
+//
+//   - {prefix}/metrics exposes the service's metrics in the Prometheus
+//     format, if metrics are configured,
+//   - {prefix}/healthz is a liveness check which succeeds if the service is
+//     able to handle requests at all, and
+//   - {prefix}/readyz is a readiness check which aggregates the registered
+//     health checks and reports a breakdown of their results.
+//
+// To avoid exposing these endpoints publicly, use AdminHandler instead and
+// serve them on a separate listener.
+func (s *Service) MountAdmin(prefix string) {
+	for _, e := range s.adminRoutes(prefix) {
+		s.Add(e.path, adaptHandler(e.handler)).Methods(e.methods...)
+	}
+}
+
+// Produce a handler which serves the administrative endpoints described by
+// MountAdmin, under the provided prefix. This is intended to be served on a
+// separate listener from the service itself.
+func (s *Service) AdminHandler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	for _, e := range s.adminRoutes(prefix) {
+		for _, m := range e.methods {
+			mux.Handle(m+" "+e.path, e.handler)
+		}
+	}
+	return mux
+}
+
+type adminRoute struct {
+	path    string
+	methods []string
+	handler http.Handler
+}
+
+func (s *Service) adminRoutes(prefix string) []adminRoute {
+	var routes []adminRoute
+	if s.metrics != nil {
+		routes = append(routes, adminRoute{path.Join("/", prefix, "metrics"), []string{"GET"}, s.metrics.handler})
+	}
+	return append(routes,
+		adminRoute{path.Join("/", prefix, "healthz"), []string{"GET", "HEAD"}, http.HandlerFunc(s.handleLiveness)},
+		adminRoute{path.Join("/", prefix, "readyz"), []string{"GET", "HEAD"}, http.HandlerFunc(s.handleReadiness)},
+	)
+}
+
+func (s *Service) handleLiveness(w http.ResponseWriter, req *http.Request) {
+	writeHealthReport(w, http.StatusOK, HealthReport{Status: healthOK})
+}
+
+func (s *Service) handleReadiness(w http.ResponseWriter, req *http.Request) {
+	report := s.CheckHealth(req.Context())
+	status := http.StatusOK
+	if report.Status != healthOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealthReport(w, status, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report HealthReport) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Adapt an http.Handler to a router handler. The response is buffered, so
+// this is not suitable for streaming handlers.
+func adaptHandler(h http.Handler) router.Handler {
+	return func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		w := &bufferedWriter{header: make(http.Header)}
+		h.ServeHTTP(w, (*http.Request)(req))
+		rsp := router.NewResponse(ext.Coalesce(w.status, http.StatusOK))
+		rsp.Header = w.header
+		rsp.Entity = io.NopCloser(&w.data)
+		return rsp, nil
+	}
+}
+
+type bufferedWriter struct {
+	header http.Header
+	status int
+	data   bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) WriteHeader(s int) {
+	if w.status == 0 {
+		w.status = s
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.data.Write(b)
+}
@@ -0,0 +1,98 @@
+// Command gorest-replay replays exchanges captured in debug mode against a
+// running service and reports any differences between the recorded responses
+// and the responses produced by the service.
+//
+// Usage:
+//
+//	gorest-replay -addr http://localhost:8080 [options] capture.har [capture.jsonl ...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	rest "github.com/bww/go-rest/v2"
+	"github.com/bww/go-rest/v2/resttest"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	os.Exit(app(os.Args[0], os.Args[1:]))
+}
+
+func app(name string, args []string) int {
+	var (
+		headers       stringList
+		ignoreHeaders stringList
+		ignoreFields  stringList
+	)
+	cmdline := flag.NewFlagSet(name, flag.ExitOnError)
+	fAddr := cmdline.String("addr", "", "The base URL of the service to replay against, e.g., http://localhost:8080.")
+	fVerbose := cmdline.Bool("verbose", false, "Report every exchange, not only those with differences.")
+	cmdline.Var(&headers, "header", "A header, as 'Name: value', to set on every replayed request. May be repeated.")
+	cmdline.Var(&ignoreHeaders, "ignore-header", "A response header to ignore when comparing. May be repeated.")
+	cmdline.Var(&ignoreFields, "ignore-field", "A JSON response field to ignore when comparing. May be repeated.")
+	cmdline.Parse(args)
+
+	if *fAddr == "" || cmdline.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s -addr <url> [options] <capture> [...]\n", name)
+		cmdline.PrintDefaults()
+		return 2
+	}
+
+	target, err := resttest.Address(*fAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "* * * %v\n", err)
+		return 2
+	}
+
+	opts := []resttest.Option{
+		resttest.IgnoreHeaders(ignoreHeaders...),
+		resttest.IgnoreFields(ignoreFields...),
+	}
+	for _, e := range headers {
+		k, v, ok := strings.Cut(e, ":")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "* * * Invalid header: %s\n", e)
+			return 2
+		}
+		opts = append(opts, resttest.WithHeader(strings.TrimSpace(k), strings.TrimSpace(v)))
+	}
+
+	var xs []*rest.DebugExchange
+	for _, e := range cmdline.Args() {
+		x, err := resttest.ReadFile(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "* * * Could not read capture: %s: %v\n", e, err)
+			return 2
+		}
+		xs = append(xs, x...)
+	}
+
+	var failed int
+	for _, e := range resttest.Replay(target, xs, opts...) {
+		if !e.OK() {
+			failed++
+			fmt.Println("FAIL", e)
+		} else if *fVerbose {
+			fmt.Println("OK  ", e)
+		}
+	}
+	fmt.Printf("%d exchanges replayed, %d failed\n", len(xs), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,236 @@
+package rest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bww/go-router/v2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content encodings
+const (
+	EncodingZstd    = "zstd"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// The default minimum size of an entity which is compressed
+const defaultCompressMinSize = 1 << 10
+
+// The default encodings, in order of preference
+var defaultEncodings = []string{EncodingZstd, EncodingGzip, EncodingDeflate}
+
+// Compression configuration
+type CompressionConfig struct {
+	// The minimum size of an entity which is compressed; smaller entities are
+	// not worth the overhead. The default is 1KiB.
+	MinSize int
+	// The encodings which may be used, in order of preference. The encoding
+	// used for a response is the one with the highest quality in the request's
+	// Accept-Encoding header; preference breaks ties. The default is zstd,
+	// gzip and deflate.
+	Encodings []string
+}
+
+type compressor struct {
+	minsize   int
+	encodings []string
+}
+
+func newCompressor(conf CompressionConfig) *compressor {
+	c := &compressor{
+		minsize:   conf.MinSize,
+		encodings: conf.Encodings,
+	}
+	if c.minsize <= 0 {
+		c.minsize = defaultCompressMinSize
+	}
+	if len(c.encodings) == 0 {
+		c.encodings = defaultEncodings
+	}
+	return c
+}
+
+// Negotiate compression for a response. If the response should be compressed
+// the header is updated accordingly and an encoder which writes to w is
+// returned; the encoder must be closed once the entity has been written.
+// Otherwise, nil is returned.
+//
+// The header is the one which will be written for the response and must
+// already include the response headers. The response entity may be replaced
+// with an equivalent reader, since some of it may be read to determine if it
+// is large enough to compress.
+func (c *compressor) encoder(req *http.Request, rsp *router.Response, header http.Header, w io.Writer) *compressWriter {
+	if rsp.Entity == nil || header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if rsp.Status < 200 || rsp.Status == http.StatusNoContent || rsp.Status == http.StatusNotModified {
+		return nil
+	}
+	if t := header.Get("Content-Type"); t == "" || isMimetypeBinary(t) {
+		return nil // binary types are generally already compressed
+	}
+
+	// from here on, the response depends on the request's accepted encodings
+	if !headerHasToken(header, "Vary", "Accept-Encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if req.Method == http.MethodHead {
+		return nil
+	}
+	enc := negotiateEncoding(req.Header.Get("Accept-Encoding"), c.encodings)
+	if enc == "" {
+		return nil
+	}
+
+	stream := isStreaming(rsp)
+	if !stream {
+		if v := header.Get("Content-Length"); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n < int64(c.minsize) {
+				return nil
+			}
+		} else {
+			buf := make([]byte, c.minsize)
+			n, err := io.ReadFull(rsp.Entity, buf)
+			rsp.Entity = &readCloser{io.MultiReader(bytes.NewReader(buf[:n]), rsp.Entity), rsp.Entity}
+			if err != nil {
+				return nil // the entity is smaller than the minimum, or it can't be read
+			}
+		}
+	}
+
+	header.Set("Content-Encoding", enc)
+	header.Del("Content-Length")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag) // the representation is no longer byte-for-byte identical
+	}
+
+	pool := encoderPools[enc]
+	e := pool.Get().(encoder)
+	e.Reset(w)
+	return &compressWriter{encoder: e, pool: pool, dst: w, stream: stream}
+}
+
+// Select an encoding from an Accept-Encoding header value. The encoding with
+// the highest quality is chosen; ties are broken by the order of preference.
+// If no encoding is acceptable, or identity is preferred, the empty string is
+// returned.
+func negotiateEncoding(accept string, prefs []string) string {
+	if accept == "" {
+		return ""
+	}
+	qvals := make(map[string]float64)
+	for _, e := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(e, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		qvals[name] = q
+	}
+
+	quality := func(name string) float64 {
+		if q, ok := qvals[name]; ok {
+			return q
+		}
+		if q, ok := qvals["*"]; ok {
+			return q
+		}
+		return 0
+	}
+
+	var best string
+	var bestq float64
+	for _, e := range prefs {
+		if _, ok := encoderPools[e]; !ok {
+			continue
+		}
+		if q := quality(e); q > bestq {
+			best, bestq = e, q
+		}
+	}
+	if q, ok := qvals["identity"]; ok && q > bestq {
+		return ""
+	}
+	return best
+}
+
+// Determine if a header carries a comma-separated list containing a token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, e := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(e), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// An encoder which can be reused for another stream once it is reset
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	EncodingDeflate: {New: func() any {
+		return zlib.NewWriter(nil) // the deflate content coding is zlib framed
+	}},
+	EncodingZstd: {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1)) // only fails for invalid options
+		return w
+	}},
+}
+
+// Compresses an entity as it is written. For streaming responses, the
+// encoder and the underlying writer are flushed after every write so that
+// events are delivered promptly.
+type compressWriter struct {
+	encoder
+	pool   *sync.Pool
+	dst    io.Writer
+	stream bool
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	n, err := w.encoder.Write(p)
+	if err != nil || !w.stream {
+		return n, err
+	}
+	if err := w.encoder.Flush(); err != nil {
+		return n, err
+	}
+	if f, ok := w.dst.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, nil
+}
+
+// Close the encoder, writing any buffered data, and return it to its pool
+func (w *compressWriter) Close() error {
+	err := w.encoder.Close()
+	w.encoder.Reset(nil)
+	w.pool.Put(w.encoder)
+	return err
+}
@@ -1,18 +1,40 @@
 package rest
 
 import (
+	"fmt"
 	"log/slog"
+	"net/http"
+	"time"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+	"github.com/bww/go-rest/v2/tracing"
 
 	"github.com/bww/go-metrics/v1"
 	"github.com/bww/go-router/v2"
 )
 
 type Config struct {
-	Default router.Handler
-	Logger  *slog.Logger
-	Metrics *metrics.Metrics
-	Verbose bool
-	Debug   bool
+	Default            router.Handler
+	PanicHandler       PanicHandler
+	ErrorHandler       ErrorHandler
+	ErrorEncoder       resterrs.Encoder
+	ErrorMappings      *resterrs.Registry
+	RequestIDHeader    string
+	RequestIDGenerator func() string
+	AccessLog          *AccessLogConfig
+	Compression        *CompressionConfig
+	HealthChecks       map[string]HealthCheck
+	HealthTimeout      time.Duration
+	Logger             *slog.Logger
+	Metrics            *metrics.Metrics
+	Tracer             *tracing.Tracer
+	Timeout            time.Duration
+	TimeoutStatus      int
+	Server             ServerConfig
+	ShutdownHooks      []ShutdownHook
+	Verbose            bool
+	Debug              bool
+	DebugConfig        DebugConfig
 }
 
 func (c Config) WithOptions(opts []Option) (Config, error) {
@@ -35,6 +57,101 @@ func WithDefault(r router.Handler) Option {
 	}
 }
 
+// Set the handler which produces a response when a request handler panics.
+// If no panic handler is set, or the handler returns nil, the error is used
+// to produce the response.
+func WithPanicHandler(h PanicHandler) Option {
+	return func(c Config) (Config, error) {
+		c.PanicHandler = h
+		return c, nil
+	}
+}
+
+// Set the handler which produces a response when a request handler returns
+// an error that cannot be converted to a response. If no error handler is
+// set, or the handler returns nil, a generic 500 response is produced.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(c Config) (Config, error) {
+		c.ErrorHandler = h
+		return c, nil
+	}
+}
+
+// Set the encoder used to produce responses for REST errors which do not
+// specify their own encoder. For example, use resterrs.Problem{} to produce
+// RFC 9457 problem details. The default is resterrs.JSON.
+func WithErrorEncoder(e resterrs.Encoder) Option {
+	return func(c Config) (Config, error) {
+		c.ErrorEncoder = e
+		return c, nil
+	}
+}
+
+// Set the registry used to convert well-known errors returned by handlers
+// into REST errors. The default is resterrs.DefaultRegistry.
+func WithErrorMappings(r *resterrs.Registry) Option {
+	return func(c Config) (Config, error) {
+		c.ErrorMappings = r
+		return c, nil
+	}
+}
+
+// Set the header from which an inbound request ID is read and in which it
+// is echoed in the response. The default is X-Request-Id.
+func WithRequestIDHeader(h string) Option {
+	return func(c Config) (Config, error) {
+		c.RequestIDHeader = h
+		return c, nil
+	}
+}
+
+// Set the function used to generate an ID for requests which do not provide
+// a valid one. The default generates a random UUID.
+func WithRequestIDGenerator(f func() string) Option {
+	return func(c Config) (Config, error) {
+		c.RequestIDGenerator = f
+		return c, nil
+	}
+}
+
+// Enable access logging. An access log entry is emitted for each request
+// after its response has been written.
+func WithAccessLog(conf AccessLogConfig) Option {
+	return func(c Config) (Config, error) {
+		c.AccessLog = &conf
+		return c, nil
+	}
+}
+
+// Enable response compression. Text entities are compressed using the
+// encoding negotiated from the request's Accept-Encoding header.
+func WithCompression(conf CompressionConfig) Option {
+	return func(c Config) (Config, error) {
+		c.Compression = &conf
+		return c, nil
+	}
+}
+
+// Register a health check which is consulted by the readiness endpoint
+func WithHealthCheck(name string, check HealthCheck) Option {
+	return func(c Config) (Config, error) {
+		if c.HealthChecks == nil {
+			c.HealthChecks = make(map[string]HealthCheck)
+		}
+		c.HealthChecks[name] = check
+		return c, nil
+	}
+}
+
+// Set the time permitted for each health check to complete. The default is
+// five seconds.
+func WithHealthTimeout(d time.Duration) Option {
+	return func(c Config) (Config, error) {
+		c.HealthTimeout = d
+		return c, nil
+	}
+}
+
 func WithVerbose(on bool) Option {
 	return func(c Config) (Config, error) {
 		c.Verbose = on
@@ -49,6 +166,45 @@ func WithDebug(on bool) Option {
 	}
 }
 
+// Set the sink which receives exchanges captured in debug mode. The default
+// sink writes exchanges to stdout.
+func WithDebugSink(k DebugSink) Option {
+	return func(c Config) (Config, error) {
+		c.DebugConfig.Sink = k
+		return c, nil
+	}
+}
+
+// Set the redaction policy applied to exchanges captured in debug mode. The
+// default policy is DefaultRedaction; provide an empty policy to disable
+// redaction entirely.
+func WithDebugRedaction(r Redaction) Option {
+	return func(c Config) (Config, error) {
+		c.DebugConfig.Redaction = &r
+		return c, nil
+	}
+}
+
+// Set the maximum number of entity bytes captured in debug mode. Larger
+// entities are truncated in the dump, but are otherwise handled normally.
+// A negative value disables the limit.
+func WithDebugMaxBody(n int) Option {
+	return func(c Config) (Config, error) {
+		c.DebugConfig.MaxBody = n
+		return c, nil
+	}
+}
+
+// Add predicates which determine whether an exchange is dumped in debug
+// mode; all predicates must be satisfied for an exchange to be dumped.
+// Providing a predicate enables debug mode.
+func WithDebugPredicate(p ...DebugPredicate) Option {
+	return func(c Config) (Config, error) {
+		c.DebugConfig.Predicates = append(c.DebugConfig.Predicates, p...)
+		return c, nil
+	}
+}
+
 func WithLogger(l *slog.Logger) Option {
 	return func(c Config) (Config, error) {
 		c.Logger = l
@@ -62,3 +218,51 @@ func WithMetrics(m *metrics.Metrics) Option {
 		return c, nil
 	}
 }
+
+// Enable tracing. A server span is started for each request, continuing the
+// trace propagated by the client via the W3C traceparent header, if any.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(c Config) (Config, error) {
+		c.Tracer = t
+		return c, nil
+	}
+}
+
+// Set the server configuration used by Run
+func WithServer(conf ServerConfig) Option {
+	return func(c Config) (Config, error) {
+		c.Server = conf
+		return c, nil
+	}
+}
+
+// Register a hook which is run when the service shuts down; see OnShutdown
+func WithShutdownHook(hook ShutdownHook) Option {
+	return func(c Config) (Config, error) {
+		c.ShutdownHooks = append(c.ShutdownHooks, hook)
+		return c, nil
+	}
+}
+
+// Set the time permitted for handlers to produce a response. A request which
+// exceeds it receives a timeout error instead; see WithTimeoutStatus. Routes
+// may override this with the Timeout route option. The default is no timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(c Config) (Config, error) {
+		c.Timeout = d
+		return c, nil
+	}
+}
+
+// Set the status of the response produced when a request times out, which
+// is either 503 Service Unavailable or 504 Gateway Timeout. The default is
+// 503.
+func WithTimeoutStatus(status int) Option {
+	return func(c Config) (Config, error) {
+		if status != http.StatusServiceUnavailable && status != http.StatusGatewayTimeout {
+			return c, fmt.Errorf("Invalid timeout status: %d", status)
+		}
+		c.TimeoutStatus = status
+		return c, nil
+	}
+}
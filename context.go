@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// Derive a context which carries the provided request ID
+func NewRequestIDContext(cxt context.Context, id string) context.Context {
+	return context.WithValue(cxt, requestIDKey, id)
+}
+
+// Obtain the ID of the request associated with a context. If the context is
+// not associated with a request, the empty string is returned.
+func RequestID(cxt context.Context) string {
+	id, _ := cxt.Value(requestIDKey).(string)
+	return id
+}
+
+// Derive a context which carries the provided logger. Handlers and middleware
+// which add attributes to the request logger can install the result in the
+// request context so that downstream logging carries those attributes.
+func NewLoggerContext(cxt context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(cxt, loggerKey, log)
+}
+
+// Obtain the logger associated with a context. A request handled by a Service
+// carries a logger with attributes describing the request, including its
+// method, resource, route and ID. If the context does not carry a logger the
+// default logger is returned.
+func Logger(cxt context.Context) *slog.Logger {
+	if log := loggerFromContext(cxt); log != nil {
+		return log
+	}
+	return slog.Default()
+}
+
+func loggerFromContext(cxt context.Context) *slog.Logger {
+	log, _ := cxt.Value(loggerKey).(*slog.Logger)
+	return log
+}
@@ -0,0 +1,190 @@
+package rest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bww/go-router/v2"
+	"github.com/bww/go-util/v1/ext"
+)
+
+// The default maximum number of entity bytes captured in a debug dump
+const defaultDebugMaxBody = 1 << 16
+
+// An entity captured in debug mode
+type DebugEntity struct {
+	Type      string `json:"type,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+	Binary    bool   `json:"binary,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Streaming bool   `json:"streaming,omitempty"`
+}
+
+// A request captured in debug mode
+type DebugRequest struct {
+	Method string       `json:"method"`
+	Scheme string       `json:"scheme,omitempty"`
+	Host   string       `json:"host,omitempty"`
+	URL    string       `json:"url"`
+	Proto  string       `json:"proto,omitempty"`
+	Header http.Header  `json:"header,omitempty"`
+	Entity *DebugEntity `json:"entity,omitempty"`
+}
+
+// A response captured in debug mode
+type DebugResponse struct {
+	Status int          `json:"status"`
+	Header http.Header  `json:"header,omitempty"`
+	Entity *DebugEntity `json:"entity,omitempty"`
+}
+
+// A request and its response, as captured in debug mode. Headers, parameters
+// and entities are redacted according to the service's redaction policy
+// before an exchange is provided to a sink.
+type DebugExchange struct {
+	Time      time.Time      `json:"time"`
+	Duration  time.Duration  `json:"duration"`
+	RequestID string         `json:"request_id,omitempty"`
+	Route     string         `json:"route,omitempty"`
+	Request   DebugRequest   `json:"request"`
+	Response  *DebugResponse `json:"response,omitempty"`
+}
+
+// Debug configuration
+type DebugConfig struct {
+	Sink      DebugSink  // the sink which receives exchanges; the default writes to stdout
+	Redaction *Redaction // the redaction policy; the default is DefaultRedaction
+	MaxBody   int        // the maximum number of entity bytes captured; negative for no limit
+
+	// Predicates which must all be satisfied for an exchange to be dumped; if
+	// none are provided, every exchange is dumped
+	Predicates []DebugPredicate
+}
+
+type debugger struct {
+	sink   DebugSink
+	redact *redactor
+	pred   debugPredicates
+	max    int
+}
+
+func newDebugger(conf DebugConfig) *debugger {
+	d := &debugger{
+		sink:   conf.Sink,
+		redact: newRedactor(DefaultRedaction),
+		pred:   conf.Predicates,
+		max:    conf.MaxBody,
+	}
+	if d.sink == nil {
+		d.sink = NewWriterSink(os.Stdout)
+	}
+	if conf.Redaction != nil {
+		d.redact = newRedactor(*conf.Redaction)
+	}
+	if d.max == 0 {
+		d.max = defaultDebugMaxBody
+	}
+	return d
+}
+
+// Determine if a request should be captured
+func (d *debugger) capture(req *http.Request, route string) bool {
+	return d.pred.Capture(req, route)
+}
+
+// Capture a request. The request entity is replaced with a reader that
+// reproduces it in full, so the request may still be handled normally.
+func (d *debugger) captureRequest(req *http.Request, reqid string, start time.Time) (*DebugExchange, error) {
+	x := &DebugExchange{
+		Time:      start,
+		RequestID: reqid,
+		Request: DebugRequest{
+			Method: req.Method,
+			Scheme: ext.Choose(req.TLS != nil, "https", "http"),
+			Host:   req.Host,
+			URL:    d.redact.URL(req.URL),
+			Proto:  req.Proto,
+			Header: d.redact.Header(req.Header),
+		},
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		ent, body, err := d.captureEntity(req.Header.Get("Content-Type"), req.Body)
+		req.Body = body
+		x.Request.Entity = ent
+		if err != nil {
+			return x, err
+		}
+	}
+	return x, nil
+}
+
+// Capture a response. If the response has an entity it is replaced with a
+// reader that reproduces it in full, so that it may still be written.
+func (d *debugger) captureResponse(x *DebugExchange, rsp *router.Response) error {
+	x.Duration = time.Since(x.Time)
+	if rsp == nil {
+		x.Response = &DebugResponse{Status: http.StatusOK}
+		return nil
+	}
+	x.Response = &DebugResponse{
+		Status: rsp.Status,
+		Header: d.redact.Header(rsp.Header),
+	}
+	if isStreaming(rsp) {
+		// NOTE: this is a very specific workaround for SSE responses, which
+		// are not generally compatible with the buffered approach we take to
+		// debugging responses.
+		x.Response.Entity = &DebugEntity{Type: rsp.Header.Get("Content-Type"), Streaming: true}
+		return nil
+	}
+	if rsp.Entity != nil {
+		ent, body, err := d.captureEntity(rsp.Header.Get("Content-Type"), rsp.Entity)
+		rsp.Entity = body
+		x.Response.Entity = ent
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Capture an entity, up to the maximum size. A reader which reproduces the
+// entity in full is returned along with the capture.
+func (d *debugger) captureEntity(mtype string, body io.ReadCloser) (*DebugEntity, io.ReadCloser, error) {
+	if mtype == "" {
+		return nil, body, nil
+	}
+	if isMimetypeBinary(mtype) {
+		return &DebugEntity{Type: mtype, Binary: true}, body, nil
+	}
+
+	var src io.Reader = body
+	if d.max > 0 {
+		src = io.LimitReader(body, int64(d.max)+1)
+	}
+	data, err := io.ReadAll(src)
+	full := &readCloser{io.MultiReader(bytes.NewReader(data), body), body}
+
+	ent := &DebugEntity{Type: mtype, Data: data}
+	if d.max > 0 && len(data) > d.max {
+		ent.Data, ent.Truncated = data[:d.max], true
+	}
+	ent.Data = d.redact.Entity(mtype, ent.Data, ent.Truncated)
+	return ent, full, err
+}
+
+// Emit an exchange to the sink, if it satisfies our predicates
+func (d *debugger) dump(x *DebugExchange) error {
+	if !d.pred.Dump(x) {
+		return nil
+	}
+	return d.sink.Dump(x)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,200 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+)
+
+// A debug predicate determines whether an exchange is dumped in debug mode.
+// Predicates are consulted twice: first when a request has been routed, to
+// determine if it should be captured at all, and then once its response has
+// been produced, to determine if the captured exchange should be dumped.
+type DebugPredicate interface {
+	// Capture determines if a request should be captured. The route is the
+	// pattern of the route matched by the request, if any.
+	Capture(req *http.Request, route string) bool
+	// Dump determines if a captured exchange should be dumped
+	Dump(x *DebugExchange) bool
+}
+
+// DebugRequestFunc adapts a function to the DebugPredicate interface. The
+// function decides whether a request is captured; every exchange which is
+// captured is dumped.
+type DebugRequestFunc func(req *http.Request, route string) bool
+
+func (f DebugRequestFunc) Capture(req *http.Request, route string) bool {
+	return f(req, route)
+}
+
+func (f DebugRequestFunc) Dump(x *DebugExchange) bool {
+	return true
+}
+
+// A set of predicates, all of which must be satisfied
+type debugPredicates []DebugPredicate
+
+func (p debugPredicates) Capture(req *http.Request, route string) bool {
+	for _, e := range p {
+		if !e.Capture(req, route) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p debugPredicates) Dump(x *DebugExchange) bool {
+	for _, e := range p {
+		if !e.Dump(x) {
+			return false
+		}
+	}
+	return true
+}
+
+// Capture a random sample of requests. The rate is the fraction of requests
+// which are captured, in [0, 1].
+func DebugSample(rate float64) DebugPredicate {
+	return DebugRequestFunc(func(req *http.Request, route string) bool {
+		return rate >= 1 || rand.Float64() < rate
+	})
+}
+
+// Capture requests which match one of the provided route patterns
+func DebugRoutes(patterns ...string) DebugPredicate {
+	match := make(map[string]struct{})
+	for _, e := range patterns {
+		match[e] = struct{}{}
+	}
+	return DebugRequestFunc(func(req *http.Request, route string) bool {
+		_, ok := match[route]
+		return ok
+	})
+}
+
+type debugStatus []int
+
+// Dump exchanges with a response status in one of the provided classes; for
+// example, DebugStatus(5) dumps only exchanges with a 5xx response.
+func DebugStatus(classes ...int) DebugPredicate {
+	return debugStatus(classes)
+}
+
+func (p debugStatus) Capture(req *http.Request, route string) bool {
+	return true
+}
+
+func (p debugStatus) Dump(x *DebugExchange) bool {
+	if x.Response == nil {
+		return false
+	}
+	for _, e := range p {
+		if x.Response.Status/100 == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Capture requests which carry the named header. If a key is provided, the
+// header value must be a valid, unexpired token produced by NewDebugToken
+// with the same key; otherwise, any value other than empty, "0" or "false"
+// enables capture.
+func DebugHeader(name string, key []byte) DebugPredicate {
+	return DebugRequestFunc(func(req *http.Request, route string) bool {
+		v := req.Header.Get(name)
+		if key == nil {
+			return v != "" && v != "0" && !strings.EqualFold(v, "false")
+		}
+		return verifyDebugToken(key, v, time.Now())
+	})
+}
+
+// Produce a token which enables debugging via DebugHeader until it expires
+func NewDebugToken(key []byte, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + signDebugToken(key, exp)
+}
+
+func signDebugToken(key []byte, exp string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyDebugToken(key []byte, token string, now time.Time) bool {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	if !hmac.Equal([]byte(sig), []byte(signDebugToken(key, exp))) {
+		return false
+	}
+	t, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return now.Before(time.Unix(t, 0))
+}
+
+// A debug toggle is a predicate which can be switched on and off at runtime.
+// Its handler can be mounted on an administrative route to control it.
+type DebugToggle struct {
+	on atomic.Bool
+}
+
+func NewDebugToggle(on bool) *DebugToggle {
+	t := &DebugToggle{}
+	t.on.Store(on)
+	return t
+}
+
+func (t *DebugToggle) Enabled() bool {
+	return t.on.Load()
+}
+
+func (t *DebugToggle) Set(on bool) {
+	t.on.Store(on)
+}
+
+func (t *DebugToggle) Capture(req *http.Request, route string) bool {
+	return t.Enabled()
+}
+
+func (t *DebugToggle) Dump(x *DebugExchange) bool {
+	return true
+}
+
+type debugToggleState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Handle requests to inspect or change the state of the toggle. A GET request
+// reports the state of the toggle and a PUT or POST request with an entity
+// of the form {"enabled": true} changes it.
+func (t *DebugToggle) Handle(req *router.Request, cxt router.Context) (*router.Response, error) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var state debugToggleState
+		err := json.NewDecoder(req.Body).Decode(&state)
+		if err != nil {
+			return nil, resterrs.New(http.StatusBadRequest, "Invalid debug state", err)
+		}
+		t.Set(state.Enabled)
+	default:
+		return nil, resterrs.Errorf(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+	return router.NewResponse(http.StatusOK).SetJSON(debugToggleState{Enabled: t.Enabled()})
+}
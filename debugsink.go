@@ -0,0 +1,209 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/bww/go-util/v1/text"
+)
+
+// A debug sink receives exchanges captured in debug mode
+type DebugSink interface {
+	Dump(*DebugExchange) error
+}
+
+// DebugSinkFunc adapts a function to the DebugSink interface
+type DebugSinkFunc func(*DebugExchange) error
+
+func (f DebugSinkFunc) Dump(x *DebugExchange) error {
+	return f(x)
+}
+
+type writerSink struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// Produce a sink which writes exchanges to the provided writer in a human
+// readable text format
+func NewWriterSink(w io.Writer) DebugSink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Dump(x *DebugExchange) error {
+	dump := formatExchange(x)
+	s.Lock()
+	defer s.Unlock()
+	_, err := io.Copy(s.w, dump)
+	return err
+}
+
+type jsonSink struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// Produce a sink which writes exchanges to the provided writer as JSON, one
+// exchange per line. This format retains everything which is captured and can
+// be read back for replay.
+func NewJSONSink(w io.Writer) DebugSink {
+	return &jsonSink{w: w}
+}
+
+func (s *jsonSink) Dump(x *DebugExchange) error {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// A sink which appends exchanges to a file in a human readable text format
+type FileSink struct {
+	writerSink
+	file *os.File
+}
+
+// Produce a sink which appends exchanges to the file at the provided path,
+// which is created if it does not exist. The sink must be closed when it is
+// no longer needed.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{writerSink{w: f}, f}, nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+type loggerSink struct {
+	log *slog.Logger
+}
+
+// Produce a sink which emits exchanges as structured log records
+func NewLoggerSink(log *slog.Logger) DebugSink {
+	return loggerSink{log}
+}
+
+func (s loggerSink) Dump(x *DebugExchange) error {
+	attrs := []any{
+		"request_id", x.RequestID,
+		"duration", x.Duration,
+		slog.Group("request",
+			"method", x.Request.Method,
+			"url", x.Request.URL,
+			"header", x.Request.Header,
+			"entity", entityString(x.Request.Entity),
+		),
+	}
+	if rsp := x.Response; rsp != nil {
+		attrs = append(attrs, slog.Group("response",
+			"status", rsp.Status,
+			"header", rsp.Header,
+			"entity", entityString(rsp.Entity),
+		))
+	}
+	s.log.Info("Debug", attrs...)
+	return nil
+}
+
+// A sink which retains the most recent exchanges in memory
+type RingSink struct {
+	sync.Mutex
+	ring []*DebugExchange
+	next int
+	full bool
+}
+
+// Produce a sink which retains, at most, the n most recent exchanges
+func NewRingSink(n int) *RingSink {
+	if n < 1 {
+		n = 1
+	}
+	return &RingSink{ring: make([]*DebugExchange, n)}
+}
+
+func (s *RingSink) Dump(x *DebugExchange) error {
+	s.Lock()
+	defer s.Unlock()
+	s.ring[s.next] = x
+	s.next = (s.next + 1) % len(s.ring)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+// Obtain the retained exchanges, oldest first
+func (s *RingSink) Exchanges() []*DebugExchange {
+	s.Lock()
+	defer s.Unlock()
+	if !s.full {
+		return append([]*DebugExchange(nil), s.ring[:s.next]...)
+	}
+	res := make([]*DebugExchange, 0, len(s.ring))
+	res = append(res, s.ring[s.next:]...)
+	return append(res, s.ring[:s.next]...)
+}
+
+// Describe an entity for display
+func entityString(e *DebugEntity) string {
+	switch {
+	case e == nil:
+		return ""
+	case e.Binary:
+		return "[binary data]"
+	case e.Streaming:
+		return "<STREAMING DATA OMITTED>"
+	case e.Truncated:
+		return string(e.Data) + "... [truncated]"
+	default:
+		return string(e.Data)
+	}
+}
+
+// Format an exchange as text
+func formatExchange(x *DebugExchange) *bytes.Buffer {
+	dump := &bytes.Buffer{}
+	dump.WriteString(text.Indent(fmt.Sprintf("%s %s", x.Request.Method, x.Request.URL), "  > "))
+	dump.WriteString("\n")
+	formatHeader(dump, x.Request.Header, "  > ")
+	formatEntity(dump, x.Request.Entity, "  > ")
+
+	if rsp := x.Response; rsp != nil {
+		fmt.Fprintf(dump, "  *\n  < %d / %s\n", rsp.Status, http.StatusText(rsp.Status))
+		formatHeader(dump, rsp.Header, "  < ")
+		if rsp.Entity != nil && rsp.Entity.Streaming {
+			dump.WriteString("  ~ <STREAMING DATA OMITTED>\n")
+		} else {
+			formatEntity(dump, rsp.Entity, "  < ")
+		}
+	}
+	return dump
+}
+
+func formatHeader(dump *bytes.Buffer, h http.Header, p string) {
+	data := &bytes.Buffer{}
+	h.Write(data)
+	dump.WriteString(text.Indent(data.String(), p))
+	dump.WriteString("\n")
+}
+
+func formatEntity(dump *bytes.Buffer, e *DebugEntity, p string) {
+	if e != nil {
+		dump.WriteString(text.Indent(entityString(e), p))
+		dump.WriteString("\n")
+	}
+}
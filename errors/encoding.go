@@ -0,0 +1,91 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bww/go-router/v2"
+)
+
+const problemContentType = "application/problem+json"
+
+// An encoder produces a response which describes an error
+type Encoder interface {
+	Encode(*Error) *router.Response
+}
+
+// EncoderFunc adapts a function to the Encoder interface
+type EncoderFunc func(*Error) *router.Response
+
+func (f EncoderFunc) Encode(e *Error) *router.Response {
+	return f(e)
+}
+
+// JSON is the default encoder. It marshals the error structure directly to
+// JSON, producing a body with code, message, detail and ref fields.
+var JSON Encoder = EncoderFunc(func(e *Error) *router.Response {
+	rsp, _ := router.NewResponse(e.Status).SetJSON(e)
+	return rsp
+})
+
+// Problem encodes errors as RFC 9457 problem details, using the media type
+// application/problem+json. Errors are mapped to problem members as follows:
+//
+//   - Code is resolved against TypeBase to produce the type URI; an error
+//     with no code has the type about:blank,
+//   - the status text is the title,
+//   - Message is the detail,
+//   - Ref is the instance,
+//   - RequestID is the request_id extension member,
+//   - TraceID is the trace_id extension member, and
+//   - each entry in Detail, including field errors, is an extension member.
+type Problem struct {
+	TypeBase string // the base URI against which error codes are resolved
+}
+
+func (p Problem) Encode(e *Error) *router.Response {
+	doc := make(map[string]interface{})
+	for k, v := range e.Detail {
+		doc[k] = v
+	}
+	if e.Code != "" {
+		doc["code"] = e.Code
+	}
+	if e.RequestID != "" {
+		doc["request_id"] = e.RequestID
+	}
+	if e.TraceID != "" {
+		doc["trace_id"] = e.TraceID
+	}
+	doc["type"] = p.typeURI(e.Code)
+	doc["title"] = http.StatusText(e.Status)
+	doc["status"] = e.Status
+	if e.Message != "" {
+		doc["detail"] = e.Message
+	}
+	if e.Ref != "" {
+		doc["instance"] = e.Ref
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return JSON.Encode(e) // fall back to the default encoding if details cannot be marshaled
+	}
+	rsp, _ := router.NewResponse(e.Status).SetBytes(problemContentType, data)
+	return rsp
+}
+
+// Produce the problem type URI for a code
+func (p Problem) typeURI(c Code) string {
+	if c == "" {
+		return "about:blank"
+	}
+	if u, err := url.Parse(string(c)); err == nil && u.IsAbs() {
+		return string(c) // the code is already an absolute URI
+	}
+	if p.TypeBase == "" {
+		return string(c)
+	}
+	return strings.TrimSuffix(p.TypeBase, "/") + "/" + url.PathEscape(string(c))
+}
@@ -31,12 +31,15 @@ type Responder interface {
 }
 
 type Error struct {
-	Status  int                    `json:"-"`
-	Code    Code                   `json:"code,omitempty"`
-	Message string                 `json:"message,omitempty"`
-	Cause   error                  `json:"-"`
-	Detail  map[string]interface{} `json:"detail,omitempty"`
-	Ref     string                 `json:"ref,omitempty"`
+	Status    int                    `json:"-"`
+	Code      Code                   `json:"code,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	Cause     error                  `json:"-"`
+	Detail    map[string]interface{} `json:"detail,omitempty"`
+	Ref       string                 `json:"ref,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	TraceID   string                 `json:"trace_id,omitempty"`
+	Encoder   Encoder                `json:"-"`
 }
 
 func New(s int, m string, c error) *Error {
@@ -114,6 +117,23 @@ func (e *Error) SetCode(c Code) *Error {
 	return e
 }
 
+// Set the encoder used to produce a response for this error. An encoder set
+// on an error takes precedence over any default.
+func (e *Error) SetEncoder(enc Encoder) *Error {
+	e.Encoder = enc
+	return e
+}
+
+func (e *Error) SetRequestID(id string) *Error {
+	e.RequestID = id
+	return e
+}
+
+func (e *Error) SetTraceID(id string) *Error {
+	e.TraceID = id
+	return e
+}
+
 func (e *Error) SetDetail(d map[string]interface{}) *Error {
 	e.Detail = d
 	return e
@@ -141,7 +161,22 @@ func (e *Error) SetFieldErrors(errs validate.Errors) *Error {
 	})
 }
 
+// Produce a response for this error using its encoder, or the default JSON
+// encoder if the error does not specify one
 func (e *Error) Response() *router.Response {
-	rsp, _ := router.NewResponse(e.Status).SetJSON(e)
-	return rsp
+	return e.ResponseWithEncoder(nil)
+}
+
+// Produce a response for this error using its encoder, or the provided
+// encoder if the error does not specify one. If neither is set, the default
+// JSON encoder is used.
+func (e *Error) ResponseWithEncoder(dflt Encoder) *router.Response {
+	enc := e.Encoder
+	if enc == nil {
+		enc = dflt
+	}
+	if enc == nil {
+		enc = JSON
+	}
+	return enc.Encode(e)
 }
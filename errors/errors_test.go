@@ -1,7 +1,11 @@
 package errors
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"net/http"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,3 +19,88 @@ func TestErrorCauseLoop(t *testing.T) {
 	e = e.SetCause(e)
 	fmt.Println("Shouldn't get here:", e)
 }
+
+func TestErrorProblem(t *testing.T) {
+	tests := []struct {
+		Err    *Error
+		Enc    Encoder
+		Type   string
+		Expect string
+	}{
+		{
+			Err:    &Error{Status: 404, Message: "Not here", Ref: "err-1"},
+			Enc:    nil,
+			Type:   "application/json",
+			Expect: `{"message":"Not here","ref":"err-1"}`,
+		},
+		{
+			Err:    &Error{Status: 404, Message: "Not here", Ref: "err-1"},
+			Enc:    Problem{},
+			Type:   "application/problem+json",
+			Expect: `{"type":"about:blank","title":"Not Found","status":404,"detail":"Not here","instance":"err-1"}`,
+		},
+		{
+			Err:    (&Error{Status: 400, Code: "bad_input", Message: "Invalid", Ref: "err-2"}).SetHelp("Try again"),
+			Enc:    Problem{TypeBase: "https://example.com/problems/"},
+			Type:   "application/problem+json",
+			Expect: `{"type":"https://example.com/problems/bad_input","title":"Bad Request","status":400,"detail":"Invalid","instance":"err-2","code":"bad_input","help":"Try again"}`,
+		},
+		{
+			Err:    (&Error{Status: 400, Message: "Invalid", Ref: "err-3"}).SetEncoder(Problem{}),
+			Enc:    JSON,
+			Type:   "application/problem+json",
+			Expect: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Invalid","instance":"err-3"}`,
+		},
+	}
+	for _, test := range tests {
+		rsp := test.Err.ResponseWithEncoder(test.Enc)
+		assert.Equal(t, test.Err.Status, rsp.Status)
+		assert.Equal(t, test.Type, rsp.Header.Get("Content-Type"))
+		data, err := rsp.ReadEntity()
+		if assert.NoError(t, err) {
+			assert.JSONEq(t, test.Expect, string(data))
+		}
+	}
+}
+
+type testMappingError struct{}
+
+func (e testMappingError) Error() string {
+	return "Test error"
+}
+
+func TestErrorMapping(t *testing.T) {
+	reg := NewRegistry(Mapping{
+		Status: http.StatusTeapot, // no matcher; this is never used
+	}, Mapping{
+		Match:   MatchAs[testMappingError](),
+		Status:  http.StatusConflict,
+		Code:    "conflict",
+		Message: "Conflict",
+	})
+	tests := []struct {
+		Reg    *Registry
+		Err    error
+		Status int
+		Code   Code
+	}{
+		{DefaultRegistry, sql.ErrNoRows, http.StatusNotFound, CodeNotFound},
+		{DefaultRegistry, fmt.Errorf("Could not open: %w", os.ErrNotExist), http.StatusNotFound, CodeNotFound},
+		{DefaultRegistry, fmt.Errorf("Too slow: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeTimeout},
+		{DefaultRegistry, context.Canceled, StatusClientClosedRequest, CodeCanceled},
+		{DefaultRegistry, testMappingError{}, 0, ""},
+		{reg, fmt.Errorf("Wrapped: %w", testMappingError{}), http.StatusConflict, "conflict"},
+		{reg, sql.ErrNoRows, 0, ""},
+	}
+	for _, test := range tests {
+		res, ok := test.Reg.Map(test.Err)
+		if test.Status == 0 {
+			assert.False(t, ok)
+			assert.Nil(t, res)
+		} else if assert.True(t, ok) {
+			assert.Equal(t, test.Status, res.Status)
+			assert.Equal(t, test.Code, res.Code)
+			assert.Equal(t, test.Err, res.Cause)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package errors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sync"
+)
+
+// Nonstandard status used when the client closes the connection before the
+// request completes. This is the status conventionally used by nginx.
+const StatusClientClosedRequest = 499
+
+const (
+	CodeNotFound Code = "not_found"
+	CodeTimeout  Code = "timeout"
+	CodeCanceled Code = "canceled"
+)
+
+// A matcher determines whether an error is described by a mapping
+type Matcher func(error) bool
+
+// Match errors which are equivalent to the target via errors.Is
+func MatchIs(target error) Matcher {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+// Match errors which can be unwrapped as the type T via errors.As
+func MatchAs[T error]() Matcher {
+	return func(err error) bool {
+		var target T
+		return errors.As(err, &target)
+	}
+}
+
+// A mapping describes how an error which satisfies its matcher should be
+// converted into a REST error
+type Mapping struct {
+	Match   Matcher
+	Status  int
+	Code    Code
+	Message string
+}
+
+// A registry of mappings which convert well-known errors into REST errors.
+// Mappings are consulted in the order they are added; the first mapping
+// which matches an error is used.
+type Registry struct {
+	mu       sync.RWMutex
+	mappings []Mapping
+}
+
+func NewRegistry(m ...Mapping) *Registry {
+	return &Registry{mappings: m}
+}
+
+// Add mappings to the registry. Mappings are appended and are therefore
+// consulted after any mappings which have already been added.
+func (r *Registry) Add(m ...Mapping) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings = append(r.mappings, m...)
+	return r
+}
+
+// Map an error to a REST error. If the error matches a mapping in the
+// registry, a new REST error is produced with the parameter as its cause
+// and true is returned. Otherwise, nil and false are returned. Mappings
+// without a matcher never match.
+func (r *Registry) Map(err error) (*Error, bool) {
+	if r == nil || err == nil {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.mappings {
+		if e.Match != nil && e.Match(err) {
+			return New(e.Status, e.Message, err).SetCode(e.Code), true
+		}
+	}
+	return nil, false
+}
+
+// The default mappings, which cover common errors produced by the standard
+// library. Mappings may be added to this registry to apply them to services
+// which use the default.
+var DefaultRegistry = NewRegistry(
+	Mapping{
+		Match:   MatchIs(sql.ErrNoRows),
+		Status:  http.StatusNotFound,
+		Code:    CodeNotFound,
+		Message: "Not found",
+	},
+	Mapping{
+		Match:   MatchIs(fs.ErrNotExist),
+		Status:  http.StatusNotFound,
+		Code:    CodeNotFound,
+		Message: "Not found",
+	},
+	Mapping{
+		Match:   MatchIs(context.DeadlineExceeded),
+		Status:  http.StatusGatewayTimeout,
+		Code:    CodeTimeout,
+		Message: "Request timed out",
+	},
+	Mapping{
+		Match:   MatchIs(context.Canceled),
+		Status:  StatusClientClosedRequest,
+		Code:    CodeCanceled,
+		Message: "Request canceled",
+	},
+)
+
+// Map an error to a REST error using the default registry
+func Map(err error) (*Error, bool) {
+	return DefaultRegistry.Map(err)
+}
@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"fmt"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+)
+
+// A panic handler produces a response when a request handler panics. It is
+// provided with the request and a 500 error describing the panic, which has
+// already been logged. The cause of the error is a *PanicError, which carries
+// the recovered value and the stack at the point the panic occurred.
+type PanicHandler func(*router.Request, *resterrs.Error) *router.Response
+
+// An error handler produces a response for an error returned by a request
+// handler which does not itself describe a response; that is, an error which
+// cannot be unwrapped as a resterrs.Responder. The error has already been
+// logged by the time the handler is invoked.
+type ErrorHandler func(*router.Request, error) *router.Response
+
+// PanicError describes a panic recovered from a request handler
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// If the recovered value is itself an error, it is unwrapped
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
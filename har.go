@@ -0,0 +1,379 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bww/go-router/v2"
+	"github.com/bww/go-util/v1/ext"
+)
+
+const harVersion = "1.2"
+
+// Comments which describe entities that are not fully represented in a HAR
+const (
+	HARCommentBinary    = "binary data omitted"
+	HARCommentStreaming = "streaming data omitted"
+	HARCommentTruncated = "truncated"
+)
+
+// An HTTP Archive (HAR) document. Only the subset of the format which can be
+// produced from captured exchanges is represented.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARNameValue `json:"params"`
+	Text     string         `json:"text"`
+	Comment  string         `json:"comment,omitempty"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Convert a captured exchange to a HAR entry
+func NewHAREntry(x *DebugExchange) HAREntry {
+	ms := float64(x.Duration) / float64(time.Millisecond)
+	e := HAREntry{
+		StartedDateTime: x.Time.Format(time.RFC3339Nano),
+		Time:            ms,
+		Request:         harRequest(x.Request),
+		Timings:         HARTimings{Wait: ms},
+		Comment:         x.RequestID,
+	}
+	if x.Response != nil {
+		e.Response = harResponse(x.Request, *x.Response)
+	} else {
+		e.Response = HARResponse{Cookies: []HARCookie{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1}
+	}
+	return e
+}
+
+func harRequest(r DebugRequest) HARRequest {
+	u, _ := url.Parse(r.URL)
+	if u == nil {
+		u = &url.URL{Path: r.URL}
+	}
+	if r.Host != "" {
+		u.Scheme, u.Host = ext.Coalesce(r.Scheme, "http"), r.Host
+	}
+	h := HARRequest{
+		Method:      r.Method,
+		URL:         u.String(),
+		HTTPVersion: ext.Coalesce(r.Proto, "HTTP/1.1"),
+		Cookies:     harCookies((&http.Request{Header: r.Header}).Cookies()),
+		Headers:     harHeaders(r.Header),
+		QueryString: harValues(u.Query()),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if e := r.Entity; e != nil {
+		h.PostData = &HARPostData{
+			MimeType: e.Type,
+			Params:   []HARNameValue{},
+			Comment:  harComment(e),
+		}
+		if !e.Binary {
+			h.PostData.Text = string(e.Data)
+			if !e.Truncated {
+				h.BodySize = len(e.Data)
+			}
+		}
+		if strings.HasPrefix(e.Type, "application/x-www-form-urlencoded") && !e.Binary {
+			if v, err := url.ParseQuery(string(e.Data)); err == nil {
+				h.PostData.Params = harValues(v)
+			}
+		}
+	} else {
+		h.BodySize = 0
+	}
+	return h
+}
+
+func harResponse(req DebugRequest, r DebugResponse) HARResponse {
+	h := HARResponse{
+		Status:      r.Status,
+		StatusText:  http.StatusText(r.Status),
+		HTTPVersion: ext.Coalesce(req.Proto, "HTTP/1.1"),
+		Cookies:     harCookies((&http.Response{Header: r.Header}).Cookies()),
+		Headers:     harHeaders(r.Header),
+		RedirectURL: r.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if e := r.Entity; e != nil {
+		h.Content = HARContent{
+			Size:     len(e.Data),
+			MimeType: e.Type,
+			Comment:  harComment(e),
+		}
+		if !e.Binary && !e.Streaming {
+			h.Content.Text = string(e.Data)
+			if !e.Truncated {
+				h.BodySize = len(e.Data)
+			}
+		}
+	} else {
+		h.Content = HARContent{MimeType: r.Header.Get("Content-Type")}
+		h.BodySize = 0
+	}
+	return h
+}
+
+func harComment(e *DebugEntity) string {
+	switch {
+	case e.Binary:
+		return HARCommentBinary
+	case e.Streaming:
+		return HARCommentStreaming
+	case e.Truncated:
+		return HARCommentTruncated
+	default:
+		return ""
+	}
+}
+
+func harHeaders(h http.Header) []HARNameValue {
+	res := make([]HARNameValue, 0, len(h))
+	for k, v := range h {
+		for _, e := range v {
+			res = append(res, HARNameValue{Name: k, Value: e})
+		}
+	}
+	return res
+}
+
+func harValues(v url.Values) []HARNameValue {
+	res := make([]HARNameValue, 0, len(v))
+	for k, v := range v {
+		for _, e := range v {
+			res = append(res, HARNameValue{Name: k, Value: e})
+		}
+	}
+	return res
+}
+
+func harCookies(c []*http.Cookie) []HARCookie {
+	res := make([]HARCookie, len(c))
+	for i, e := range c {
+		res[i] = HARCookie{Name: e.Name, Value: e.Value}
+	}
+	return res
+}
+
+// The default time to wait after an exchange is recorded before the archive
+// file is written, so that bursts of exchanges are written together
+const defaultHARFlushDelay = time.Second
+
+// A debug sink which records exchanges as HAR entries. The most recent
+// exchanges are retained in memory, and may be written to a file, served via
+// the sink's handler, or both.
+type HARSink struct {
+	ring  *RingSink
+	path  string
+	delay time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer // the pending write of the archive file, if any
+	err   error       // the most recent error writing the archive file
+
+	pending sync.WaitGroup // scheduled writes of the archive file
+	wmu     sync.Mutex     // serializes writes to the archive file
+}
+
+// Produce a sink which retains, at most, the n most recent exchanges
+func NewHARSink(n int) *HARSink {
+	return &HARSink{ring: NewRingSink(n), delay: defaultHARFlushDelay}
+}
+
+// Set the path of a file which is updated with the archive after exchanges
+// are recorded. The file is written in the background, shortly after an
+// exchange is recorded, rather than while handling the request; call Close
+// to write any pending update.
+func (s *HARSink) SetFile(path string) *HARSink {
+	s.path = path
+	return s
+}
+
+// Set the time to wait after an exchange is recorded before the archive file
+// is written. The default is one second.
+func (s *HARSink) SetFlushDelay(d time.Duration) *HARSink {
+	s.delay = d
+	return s
+}
+
+func (s *HARSink) Dump(x *DebugExchange) error {
+	err := s.ring.Dump(x)
+	if err != nil {
+		return err
+	}
+	if s.path != "" {
+		s.scheduleFlush()
+	}
+	return nil
+}
+
+// Schedule a write of the archive file, unless one is already pending
+func (s *HARSink) scheduleFlush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer == nil {
+		s.pending.Add(1)
+		s.timer = time.AfterFunc(s.delay, s.flush)
+	}
+}
+
+// Write the archive file, recording any error
+func (s *HARSink) flush() {
+	defer s.pending.Done()
+	s.mu.Lock()
+	s.timer = nil
+	s.mu.Unlock()
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	err := s.WriteFile(s.path)
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// Write any pending update to the archive file and wait for it to complete.
+// The error returned is the one produced by the most recent write, if it
+// failed.
+func (s *HARSink) Close() error {
+	s.mu.Lock()
+	stopped := s.timer != nil && s.timer.Stop()
+	s.mu.Unlock()
+	if stopped {
+		s.flush() // the timer will not run it
+	}
+	s.pending.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Produce an archive of the retained exchanges
+func (s *HARSink) HAR() *HAR {
+	xs := s.ring.Exchanges()
+	entries := make([]HAREntry, len(xs))
+	for i, e := range xs {
+		entries[i] = NewHAREntry(e)
+	}
+	return &HAR{
+		Log: HARLog{
+			Version: harVersion,
+			Creator: HARCreator{Name: "go-rest", Version: "v2"},
+			Entries: entries,
+		},
+	}
+}
+
+// Write the archive to the provided writer
+func (s *HARSink) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(s.HAR(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Write the archive to the file at the provided path. The file is replaced
+// atomically.
+func (s *HARSink) WriteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = s.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Handle a request for the archive of retained exchanges
+func (s *HARSink) Handle(req *router.Request, cxt router.Context) (*router.Response, error) {
+	return router.NewResponse(http.StatusOK).SetJSON(s.HAR())
+}
@@ -0,0 +1,292 @@
+package httputil
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+	"github.com/bww/go-validate/v1"
+)
+
+// Parameter sources, which are also the struct tags used to bind them
+const (
+	SourceQuery  = "query"
+	SourcePath   = "path"
+	SourceHeader = "header"
+	SourceCookie = "cookie"
+)
+
+var bindSources = []string{SourceQuery, SourcePath, SourceHeader, SourceCookie}
+
+var errUnsupportedBindType = errors.New("Unsupported parameter type")
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// Bind request parameters to the fields of the struct pointed to by dst. Each
+// field is bound from the source named by its tag:
+//
+//	type Params struct {
+//		ID     string        `path:"id"`
+//		Limit  int           `query:"limit" default:"10"`
+//		Tags   []string      `query:"tag,csv"`
+//		Since  time.Time     `query:"since" layout:"2006-01-02"`
+//		Tenant string        `header:"X-Tenant,required"`
+//		Wait   time.Duration `cookie:"wait"`
+//	}
+//
+// Path parameters are the variables captured by the route. Strings, booleans,
+// numbers, times, durations and types which implement
+// encoding.TextUnmarshaler are supported, as are pointers to and slices of
+// them. Slices are bound from repeated parameters or, with the csv option,
+// from comma-separated values. Times are parsed as RFC 3339 unless a layout
+// is provided.
+//
+// A field with the required option must be provided, and a field with a
+// default tag takes its default when the parameter is absent. Embedded
+// structs are bound recursively.
+//
+// If any parameter is missing or invalid, a 400 error which names it is
+// returned; every such parameter is described by a field error.
+func Bind(req *router.Request, cxt router.Context, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Bind destination must be a pointer to a struct; got %T", dst)
+	}
+	b := &binder{req: req, vars: cxt.Vars}
+	if err := b.bind(v.Elem()); err != nil {
+		return err
+	}
+	if len(b.errs) > 0 {
+		return b.error()
+	}
+	return nil
+}
+
+type bindError struct {
+	source, name string
+	code         resterrs.Code
+	message      string
+}
+
+type binder struct {
+	req   *router.Request
+	vars  map[string]string
+	query map[string][]string
+	errs  []bindError
+}
+
+func (b *binder) bind(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := b.bind(v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		for _, src := range bindSources {
+			tag, ok := f.Tag.Lookup(src)
+			if !ok || tag == "-" {
+				continue
+			}
+			if err := b.bindField(v.Field(i), f, src, tag); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (b *binder) bindField(v reflect.Value, f reflect.StructField, src, tag string) error {
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	var required, csv bool
+	for _, e := range strings.Split(opts, ",") {
+		switch e {
+		case "required":
+			required = true
+		case "csv":
+			csv = true
+		}
+	}
+
+	vals := b.values(src, name)
+	if len(vals) == 0 {
+		if d, ok := f.Tag.Lookup("default"); ok {
+			vals = []string{d}
+		} else if required {
+			b.errs = append(b.errs, bindError{src, name, CodeMissingParameter, "Parameter is required"})
+			return nil
+		} else {
+			return nil
+		}
+	}
+	if csv {
+		var split []string
+		for _, e := range vals {
+			for _, x := range strings.Split(e, ",") {
+				split = append(split, strings.TrimSpace(x))
+			}
+		}
+		vals = split
+	}
+
+	layout := f.Tag.Get("layout")
+	if v.Kind() == reflect.Slice && !implementsText(v.Type()) {
+		s := reflect.MakeSlice(v.Type(), len(vals), len(vals))
+		for i, e := range vals {
+			if err := convert(s.Index(i), e, layout); err != nil {
+				return b.invalid(src, name, err)
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	if err := convert(v, vals[0], layout); err != nil {
+		return b.invalid(src, name, err)
+	}
+	return nil
+}
+
+// Record an invalid parameter. An unsupported field type is a programming
+// error rather than a problem with the request, so it is returned instead.
+func (b *binder) invalid(src, name string, err error) error {
+	if errors.Is(err, errUnsupportedBindType) {
+		return fmt.Errorf("Cannot bind %s parameter %s: %w", src, name, err)
+	}
+	b.errs = append(b.errs, bindError{src, name, CodeInvalidParameter, err.Error()})
+	return nil
+}
+
+// Obtain the values of a parameter from a source
+func (b *binder) values(src, name string) []string {
+	switch src {
+	case SourceQuery:
+		if b.query == nil {
+			b.query = b.req.URL.Query()
+		}
+		return b.query[name]
+	case SourcePath:
+		if v, ok := b.vars[name]; ok {
+			return []string{v}
+		}
+	case SourceHeader:
+		return b.req.Header.Values(name)
+	case SourceCookie:
+		var vals []string
+		for _, e := range (*http.Request)(b.req).Cookies() {
+			if e.Name == name {
+				vals = append(vals, e.Value)
+			}
+		}
+		return vals
+	}
+	return nil
+}
+
+// Produce an error describing every parameter which could not be bound; the
+// first one determines the message and code
+func (b *binder) error() *resterrs.Error {
+	first := b.errs[0]
+	ferrs := make(validate.Errors, len(b.errs))
+	for i, e := range b.errs {
+		ferrs[i] = validate.FieldErrorf(e.name, "%s", e.message)
+	}
+	return resterrs.Errorf(http.StatusBadRequest, "Invalid %s parameter: %s: %s", first.source, first.name, first.message).
+		SetCode(first.code).
+		AddDetail(map[string]interface{}{"parameter": first.name, "source": first.source}).
+		SetFieldErrors(ferrs)
+}
+
+func implementsText(t reflect.Type) bool {
+	return t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// Convert a parameter value and assign it to v
+func convert(v reflect.Value, s, layout string) error {
+	t := v.Type()
+	if t.Kind() == reflect.Pointer {
+		p := reflect.New(t.Elem())
+		if err := convert(p.Elem(), s, layout); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	switch {
+	case t == timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		d, err := time.Parse(layout, s)
+		if err != nil {
+			return fmt.Errorf("Expected a time")
+		}
+		v.Set(reflect.ValueOf(d))
+		return nil
+	case t == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("Expected a duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	case reflect.PointerTo(t).Implements(textUnmarshalerType):
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("Invalid value: %v", err)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("Expected a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return fmt.Errorf("Expected an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return fmt.Errorf("Expected a non-negative integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return fmt.Errorf("Expected a number")
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("%w: %v", errUnsupportedBindType, t)
+	}
+	return nil
+}
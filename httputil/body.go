@@ -0,0 +1,112 @@
+package httputil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bww/go-router/v2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// default maximum request entity size, both as transmitted and once decoded
+const maxBody = 1 << 25
+
+var errDecodedTooLarge = errors.New("Decoded request entity is too large")
+
+// Prepare the request entity to be read. The entity is limited to the
+// maximum body size and, if it is compressed, it is transparently decoded
+// and limited to the maximum decoded size. The request body is replaced
+// with the prepared entity; the returned function releases any resources
+// held to decode it and must be called once it has been read.
+func prepareBody(req *router.Request, conf Config) (func(), error) {
+	if conf.MaxBody > 0 && req.ContentLength > conf.MaxBody {
+		return nil, errTooLarge(fmt.Errorf("Content-Length %d exceeds limit %d", req.ContentLength, conf.MaxBody))
+	}
+	var body io.ReadCloser = req.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	if conf.MaxBody > 0 {
+		body = http.MaxBytesReader(nil, body, conf.MaxBody)
+	}
+
+	var dec io.ReadCloser
+	var err error
+	enc := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	switch enc {
+	case "", "identity":
+		req.Body = body
+		return func() {}, nil
+	case "gzip", "x-gzip":
+		dec, err = gzip.NewReader(body)
+	case "deflate":
+		dec, err = zlib.NewReader(body)
+	case "zstd":
+		zopts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if conf.MaxDecoded > 0 {
+			zopts = append(zopts, zstd.WithDecoderMaxMemory(uint64(conf.MaxDecoded)))
+		}
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(body, zopts...)
+		if err == nil {
+			dec = d.IOReadCloser()
+		}
+	default:
+		return nil, errUnsupportedEncoding(enc)
+	}
+	if err != nil {
+		if tooLarge(err) {
+			return nil, errTooLarge(err)
+		}
+		return nil, errMalformed("Could not decode request entity", err)
+	}
+
+	var src io.Reader = dec
+	if conf.MaxDecoded > 0 {
+		src = &limitReader{dec, conf.MaxDecoded, errDecodedTooLarge}
+	}
+	req.Body = &readCloser{src, body}
+	req.ContentLength = -1
+	req.Header.Del("Content-Encoding")
+	return func() { dec.Close() }, nil
+}
+
+// Like io.LimitReader, but reading past the limit is an error instead of EOF
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// the limit has been reached; if there is more data, the entity is too large
+		var b [1]byte
+		if n, _ := l.r.Read(b[:]); n > 0 {
+			return 0, l.err
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Determine if an error describes an entity which exceeds a size limit
+func tooLarge(err error) bool {
+	var maxerr *http.MaxBytesError
+	return errors.As(err, &maxerr) || errors.Is(err, errDecodedTooLarge) || errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded)
+}
@@ -0,0 +1,296 @@
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Media types with a built-in decoder
+const (
+	MimetypeJSON    = "application/json"
+	MimetypeXML     = "application/xml"
+	MimetypeYAML    = "application/yaml"
+	MimetypeMsgpack = "application/msgpack"
+	MimetypeCBOR    = "application/cbor"
+)
+
+// A Decoder unmarshals request entities of a particular media type. The
+// entity data is bounded by the configured size limits and has already been
+// decompressed.
+//
+// If the returned error is a *resterrs.Error it is returned to the caller
+// as-is; otherwise it is reported as a malformed entity.
+type Decoder interface {
+	Decode(data []byte, entity interface{}, conf Config) error
+}
+
+// A decoder of a binary format implements BinaryDecoder so that its entities
+// are not treated as text. Otherwise, an entity which consists only of
+// whitespace is considered empty, which is not the case for binary formats
+// in which whitespace bytes are valid values.
+type BinaryDecoder interface {
+	Decoder
+	Binary()
+}
+
+// A function which implements Decoder, for decoders which do not need the
+// configuration
+type DecoderFunc func(data []byte, entity interface{}) error
+
+func (f DecoderFunc) Decode(data []byte, entity interface{}, conf Config) error {
+	return f(data, entity)
+}
+
+type decoderRegistry struct {
+	sync.RWMutex
+	decoders map[string]Decoder
+}
+
+var decoders = &decoderRegistry{
+	decoders: map[string]Decoder{
+		MimetypeJSON:              jsonDecoder{},
+		"+json":                   jsonDecoder{},
+		MimetypeXML:               xmlDecoder{},
+		"text/xml":                xmlDecoder{},
+		"+xml":                    xmlDecoder{},
+		MimetypeYAML:              yamlDecoder{},
+		"application/x-yaml":      yamlDecoder{},
+		"text/yaml":               yamlDecoder{},
+		"+yaml":                   yamlDecoder{},
+		MimetypeMsgpack:           msgpackDecoder{},
+		"application/x-msgpack":   msgpackDecoder{},
+		"application/vnd.msgpack": msgpackDecoder{},
+		MimetypeCBOR:              cborDecoder{},
+		"+cbor":                   cborDecoder{},
+	},
+}
+
+// Register a decoder for a media type, which is used by every subsequent call
+// to Unmarshal; this replaces any decoder previously registered for it. A
+// structured syntax suffix, like "+json", may be registered to decode every
+// media type with that suffix, like "application/problem+json", for which
+// there is no more specific decoder.
+//
+// Use WithDecoder to register a decoder for a single call instead.
+func RegisterDecoder(mimetype string, dec Decoder) {
+	decoders.Lock()
+	defer decoders.Unlock()
+	decoders.decoders[strings.ToLower(mimetype)] = dec
+}
+
+// Find the decoder for a media type, preferring the decoders in the
+// configuration to those which are registered, and an exact match to a
+// structured syntax suffix
+func (c Config) decoder(mimetype string) (Decoder, bool) {
+	m := strings.ToLower(mimetype)
+	var suffix string
+	if _, sub, ok := strings.Cut(m, "/"); ok {
+		if i := strings.LastIndex(sub, "+"); i >= 0 {
+			suffix = sub[i:]
+		}
+	}
+
+	decoders.RLock()
+	defer decoders.RUnlock()
+	for _, k := range []string{m, suffix} {
+		if k == "" {
+			continue
+		}
+		if d, ok := c.Decoders[k]; ok {
+			return d, true
+		}
+		if d, ok := decoders.decoders[k]; ok {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// The media types which can be unmarshaled with a configuration. Structured
+// syntax suffixes are described as a wildcard, like "*/*+json".
+func (c Config) supportedMimetypes() []string {
+	set := make(map[string]struct{})
+	for _, e := range formMimetypes {
+		set[e] = struct{}{}
+	}
+	decoders.RLock()
+	for k := range decoders.decoders {
+		set[k] = struct{}{}
+	}
+	decoders.RUnlock()
+	for k := range c.Decoders {
+		set[k] = struct{}{}
+	}
+
+	res := make([]string, 0, len(set))
+	for k := range set {
+		if strings.HasPrefix(k, "+") {
+			k = "*/*" + k
+		}
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Read and decode a request entity with a decoder
+func decodeEntity(dec Decoder, body io.Reader, entity interface{}, conf Config) error {
+	// the entity is read in full so that the position of any error in it can
+	// be described; its size is bounded by the configured limits
+	data, err := io.ReadAll(body)
+	if tooLarge(err) {
+		return errTooLarge(err)
+	} else if err != nil {
+		return errMalformed("Could not read request entity", err)
+	}
+	empty := len(data) == 0
+	if _, ok := dec.(BinaryDecoder); !ok {
+		empty = len(bytes.TrimSpace(data)) == 0
+	}
+	if empty {
+		return errMalformed("Request entity is empty", io.EOF)
+	}
+	err = dec.Decode(data, entity, conf)
+	if err == nil {
+		return nil
+	} else if rerr, ok := err.(*resterrs.Error); ok {
+		return rerr
+	} else {
+		return errMalformed("Could not unmarshal request entity: "+err.Error(), err)
+	}
+}
+
+// Decodes JSON, honoring the strictness options in the configuration
+type jsonDecoder struct{}
+
+func (d jsonDecoder) Decode(data []byte, entity interface{}, conf Config) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if conf.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if conf.UseNumber {
+		dec.UseNumber()
+	}
+	err := dec.Decode(entity)
+	if err != nil {
+		return errMalformedJSON(data, err)
+	}
+	if conf.DisallowTrailingData {
+		rest := data[dec.InputOffset():]
+		if _, err := dec.Token(); err != io.EOF {
+			return errTrailingJSON(data, int64(len(data)-len(bytes.TrimLeft(rest, " \t\r\n"))))
+		}
+	}
+	return nil
+}
+
+// Produced when an entity is followed by unexpected data and trailing data
+// is disallowed
+var errTrailingData = errors.New("unexpected data after entity")
+
+// Decodes XML; fields are mapped by their xml struct tags. Whitespace,
+// comments and processing instructions may follow the entity.
+type xmlDecoder struct{}
+
+func (d xmlDecoder) Decode(data []byte, entity interface{}, conf Config) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(entity); err != nil {
+		return err
+	}
+	if !conf.DisallowTrailingData {
+		return nil
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.Comment, xml.ProcInst:
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return errTrailingData
+			}
+		default:
+			return errTrailingData
+		}
+	}
+}
+
+// Decodes YAML; fields are mapped by their yaml struct tags. If trailing data
+// is disallowed, the entity must be a single document.
+type yamlDecoder struct{}
+
+func (d yamlDecoder) Decode(data []byte, entity interface{}, conf Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(conf.DisallowUnknownFields)
+	if err := dec.Decode(entity); err != nil {
+		return err
+	}
+	if conf.DisallowTrailingData {
+		if err := dec.Decode(new(yaml.Node)); err != io.EOF {
+			return errTrailingData
+		}
+	}
+	return nil
+}
+
+// Decodes MessagePack; fields are mapped by their msgpack struct tags or,
+// failing that, their json struct tags
+type msgpackDecoder struct{}
+
+func (d msgpackDecoder) Binary() {}
+
+func (d msgpackDecoder) Decode(data []byte, entity interface{}, conf Config) error {
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(conf.DisallowUnknownFields)
+	if err := dec.Decode(entity); err != nil {
+		return err
+	}
+	if conf.DisallowTrailingData && r.Len() > 0 {
+		return errTrailingData
+	}
+	return nil
+}
+
+// Decodes CBOR; fields are mapped by their cbor struct tags or, failing that,
+// their json struct tags
+type cborDecoder struct{}
+
+var (
+	cborDecMode, _       = cbor.DecOptions{}.DecMode() // only fails for invalid options
+	cborStrictDecMode, _ = cbor.DecOptions{ExtraReturnErrors: cbor.ExtraDecErrorUnknownField}.DecMode()
+)
+
+func (d cborDecoder) Binary() {}
+
+func (d cborDecoder) Decode(data []byte, entity interface{}, conf Config) error {
+	mode := cborDecMode
+	if conf.DisallowUnknownFields {
+		mode = cborStrictDecMode
+	}
+	rest, err := mode.UnmarshalFirst(data, entity)
+	if err != nil {
+		return err
+	}
+	if conf.DisallowTrailingData && len(rest) > 0 {
+		return errTrailingData
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package httputil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-validate/v1"
+	"github.com/gorilla/schema"
+)
+
+// Error codes produced when a request entity cannot be unmarshaled or its
+// parameters cannot be bound. These are stable and may be relied upon by
+// clients.
+const (
+	CodeUnsupportedMediaType resterrs.Code = "unsupported_media_type"
+	CodeUnsupportedEncoding  resterrs.Code = "unsupported_content_encoding"
+	CodeMalformedEntity      resterrs.Code = "malformed_entity"
+	CodeEntityTooLarge       resterrs.Code = "entity_too_large"
+	CodeInvalidEntity        resterrs.Code = "invalid_entity"
+	CodeInvalidParameter     resterrs.Code = "invalid_parameter"
+	CodeMissingParameter     resterrs.Code = "missing_parameter"
+)
+
+// The form media types, which are unmarshaled without a decoder
+var formMimetypes = []string{
+	"application/x-www-form-urlencoded",
+	"multipart/form-data",
+}
+
+func errUnsupportedMimetype(m string, conf Config) *resterrs.Error {
+	return resterrs.New(http.StatusUnsupportedMediaType, "Unsupported content type: "+m, ErrUnsupportedMimetype).
+		SetCode(CodeUnsupportedMediaType).
+		AddDetail(map[string]interface{}{"supported": conf.supportedMimetypes()})
+}
+
+func errUnsupportedEncoding(enc string) *resterrs.Error {
+	return resterrs.Errorf(http.StatusUnsupportedMediaType, "Unsupported content encoding: %s", enc).
+		SetCode(CodeUnsupportedEncoding).
+		AddDetail(map[string]interface{}{"supported": []string{"gzip", "deflate", "zstd"}})
+}
+
+func errTooLarge(err error) *resterrs.Error {
+	return resterrs.New(http.StatusRequestEntityTooLarge, "Request entity too large", err).SetCode(CodeEntityTooLarge)
+}
+
+func errMalformed(m string, err error) *resterrs.Error {
+	return resterrs.New(http.StatusBadRequest, m, err).SetCode(CodeMalformedEntity)
+}
+
+// Produce an error for a JSON entity which could not be decoded. The error
+// describes where in the entity decoding failed and, if possible, the field
+// which could not be decoded. Field errors describing the failure are
+// attached in the same form as validation errors.
+func errMalformedJSON(data []byte, err error) *resterrs.Error {
+	var (
+		synerr *json.SyntaxError
+		typerr *json.UnmarshalTypeError
+		detail = make(map[string]interface{})
+		offset = int64(-1)
+		ferr   *validate.FieldError
+	)
+	if errors.As(err, &synerr) {
+		offset = synerr.Offset
+		ferr = validate.FieldErrorf("", "%s", strings.TrimPrefix(synerr.Error(), "json: "))
+	} else if errors.As(err, &typerr) {
+		offset = typerr.Offset
+		field := fieldPath(typerr.Field)
+		if field != "" {
+			detail["field"] = field
+		}
+		ferr = validate.FieldErrorf(field, "Expected %s, found %s", jsonKind(typerr.Type), typerr.Value)
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		offset = int64(len(data))
+		ferr = validate.FieldErrorf("", "Unexpected end of entity")
+	} else if f, ok := unknownField(err); ok {
+		detail["field"] = f
+		ferr = validate.FieldErrorf(f, "Unknown field")
+	}
+	if offset >= 0 {
+		line, col := position(data, max(offset-1, 0)) // the offset follows the byte which failed
+		detail["offset"] = offset
+		detail["line"] = line
+		detail["column"] = col
+	}
+	rerr := errMalformed("Could not unmarshal request entity: "+err.Error(), err).AddDetail(detail)
+	if ferr != nil {
+		rerr.SetFieldErrors(validate.Errors{ferr})
+	}
+	return rerr
+}
+
+// Produce an error for a JSON entity which is followed by unexpected data
+func errTrailingJSON(data []byte, offset int64) *resterrs.Error {
+	line, col := position(data, offset)
+	return errMalformed("Could not unmarshal request entity: unexpected data after entity", nil).
+		AddDetail(map[string]interface{}{"offset": offset, "line": line, "column": col}).
+		SetFieldErrors(validate.Errors{validate.FieldErrorf("", "Unexpected data after entity")})
+}
+
+// The encoding/json package reports unknown fields with an untyped error;
+// extract the field name from it
+func unknownField(err error) (string, bool) {
+	const prefix = `json: unknown field "`
+	if m := err.Error(); strings.HasPrefix(m, prefix) {
+		return strings.TrimSuffix(strings.TrimPrefix(m, prefix), `"`), true
+	}
+	return "", false
+}
+
+// Convert a dotted field path, as reported by encoding/json, to the form
+// used by validation errors, where array indexes are subscripts; for example,
+// "a.b.1" becomes "a.b[1]"
+func fieldPath(p string) string {
+	if p == "" {
+		return p
+	}
+	sb := &strings.Builder{}
+	for i, e := range strings.Split(p, ".") {
+		if _, err := strconv.Atoi(e); err == nil && i > 0 {
+			sb.WriteString("[" + e + "]")
+		} else {
+			if i > 0 {
+				sb.WriteString(".")
+			}
+			sb.WriteString(e)
+		}
+	}
+	return sb.String()
+}
+
+// Describe the JSON kind which corresponds to a Go type
+func jsonKind(t reflect.Type) string {
+	if t == nil {
+		return "value"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return t.String()
+	}
+}
+
+// Produce an error for a form which could not be decoded into an entity.
+// Field errors describing each field which could not be decoded are attached
+// in the same form as validation errors.
+func errMalformedForm(err error) *resterrs.Error {
+	rerr := errMalformed("Could not unmarshal request entity: "+err.Error(), err)
+	if multi, ok := err.(schema.MultiError); ok {
+		fields := make([]string, 0, len(multi))
+		for k := range multi {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		ferrs := make(validate.Errors, len(fields))
+		for i, e := range fields {
+			ferrs[i] = validate.FieldErrorf(e, "%s", formErrorMessage(multi[e]))
+		}
+		rerr.SetFieldErrors(ferrs)
+	}
+	return rerr
+}
+
+func formErrorMessage(err error) string {
+	var converr schema.ConversionError
+	if errors.As(err, &converr) {
+		return fmt.Sprintf("Expected %s", converr.Type)
+	}
+	return err.Error()
+}
+
+// Determine the 1-based line and column of the byte at an offset in a document
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, col := 1, 1
+	for _, c := range data[:offset] {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
@@ -1,7 +1,6 @@
 package httputil
 
 import (
-	"encoding/json"
 	"fmt"
 	"mime"
 	"net/http"
@@ -25,50 +24,86 @@ func init() {
 
 // Unmarshal requests with common entity types
 func Unmarshal(req *router.Request, entity interface{}, opts ...Option) error {
-	return UnmarshalWithConfig(req, entity, Config{
-		MaxMem: maxMem,
-	}.WithOptions(opts))
+	return UnmarshalWithConfig(req, entity, newConfig(opts))
+}
+
+// Produce a configuration with defaults and the provided options applied
+func newConfig(opts []Option) Config {
+	return Config{
+		MaxMem:     maxMem,
+		MaxBody:    maxBody,
+		MaxDecoded: maxBody,
+	}.WithOptions(opts)
 }
 
 // Unmarshal requests with common entity types:
-//   - application/json
+//   - application/json, and any type with the +json suffix
+//   - application/xml and text/xml, and any type with the +xml suffix
+//   - application/yaml, and any type with the +yaml suffix
+//   - application/msgpack
+//   - application/cbor, and any type with the +cbor suffix
 //   - application/x-www-form-urlencoded
 //   - multipart/form-data
+//
+// Other types are supported by registering a Decoder for them, either with
+// RegisterDecoder or, for a single call, with the WithDecoder option.
+//
+// Multipart forms are buffered in full and file parts are left for the
+// caller to obtain from the request; use UnmarshalMultipart to stream them
+// instead.
+//
+// Entities compressed with gzip, deflate or zstd, as indicated by the
+// Content-Encoding header, are decoded transparently.
+//
+// Errors are *resterrs.Error values with stable codes: 415 for an
+// unsupported content type or encoding, which lists the supported ones; 400
+// for a malformed entity, which describes where decoding failed; and 413
+// for an entity which exceeds the configured size limits. An unsupported
+// content type error wraps ErrUnsupportedMimetype.
 func UnmarshalWithConfig(req *router.Request, entity interface{}, conf Config) error {
-	m, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	ctype := req.Header.Get("Content-Type")
+	m, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return errUnsupportedMimetype(ctype, conf)
+	}
+	release, err := prepareBody(req, conf)
 	if err != nil {
 		return err
 	}
-	switch strings.ToLower(m) {
+	defer release()
 
-	case "application/json":
-		err := json.NewDecoder(req.Body).Decode(entity)
-		if err != nil {
-			return fmt.Errorf("Could not unmarshal request entity: %v", err)
-		}
+	switch strings.ToLower(m) {
 
 	case "multipart/form-data":
 		err := (*http.Request)(req).ParseMultipartForm(conf.MaxMem)
-		if err != nil {
-			return fmt.Errorf("Could not parse multipart form: %v", err)
+		if tooLarge(err) {
+			return errTooLarge(err)
+		} else if err != nil {
+			return errMalformed("Could not parse multipart form", err)
 		}
 		err = formDecoder.Decode(entity, req.Form) // caller must access multipart data separately
 		if err != nil {
-			return fmt.Errorf("Could not unmarshal request entity: %v", err)
+			return errMalformedForm(err)
 		}
 
 	case "application/x-www-form-urlencoded":
 		err := (*http.Request)(req).ParseForm()
-		if err != nil {
-			return fmt.Errorf("Could not parse payload: %v", err)
+		if tooLarge(err) {
+			return errTooLarge(err)
+		} else if err != nil {
+			return errMalformed("Could not parse form", err)
 		}
 		err = formDecoder.Decode(entity, req.Form)
 		if err != nil {
-			return fmt.Errorf("Could not unmarshal request entity: %v", err)
+			return errMalformedForm(err)
 		}
 
 	default:
-		return ErrUnsupportedMimetype
+		dec, ok := conf.decoder(m)
+		if !ok {
+			return errUnsupportedMimetype(m, conf)
+		}
+		return decodeEntity(dec, req.Body, entity, conf)
 
 	}
 	return nil
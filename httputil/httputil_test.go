@@ -0,0 +1,588 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+	"github.com/bww/go-validate/v1"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type testEntity struct {
+	A string `json:"a" schema:"a"`
+	B int    `json:"b" schema:"b"`
+}
+
+func mustReq(ctype, cenc string, body []byte) *router.Request {
+	req, err := http.NewRequest("POST", "/", bytes.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Content-Type", ctype)
+	if cenc != "" {
+		req.Header.Set("Content-Encoding", cenc)
+	}
+	return (*router.Request)(req)
+}
+
+func compress(enc string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch enc {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "zstd":
+		enc, _ := zstd.NewWriter(nil, zstd.WithSingleSegment(true))
+		return enc.EncodeAll(data, nil) // the window is the content size, which the decoder limits
+	default:
+		return data
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func multipartBody(fields map[string]string) ([]byte, string) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	w.Close()
+	return buf.Bytes(), w.FormDataContentType()
+}
+
+func statusOf(err error) int {
+	if resterr, ok := err.(*resterrs.Error); ok {
+		return resterr.Status
+	}
+	return 0
+}
+
+func TestUnmarshalLimits(t *testing.T) {
+	small := []byte(`{"a":"Hello","b":1}`)
+	large := []byte(`{"a":"` + strings.Repeat("x", 4096) + `","b":1}`)
+	huge := []byte(`{"a":"` + strings.Repeat("x", maxBody+1) + `","b":1}`)
+	form := []byte(`a=Hello&b=1`)
+	largeForm := []byte(`a=` + strings.Repeat("x", 4096) + `&b=1`)
+	mpart, mtype := multipartBody(map[string]string{"a": "Hello", "b": "1"})
+	largeMpart, largeMtype := multipartBody(map[string]string{"a": strings.Repeat("x", 4096), "b": "1"})
+
+	tests := []struct {
+		Type     string
+		Encoding string
+		Data     []byte
+		Opts     []Option
+		Status   int
+	}{
+		{"application/json", "", small, nil, 0},
+		{"application/json", "gzip", small, nil, 0},
+		{"application/json", "deflate", small, nil, 0},
+		{"application/json", "zstd", small, nil, 0},
+		{"application/json", "br", small, nil, http.StatusUnsupportedMediaType},
+		{"application/json", "", large, []Option{MaximumBodySize(1024)}, http.StatusRequestEntityTooLarge},
+		{"application/json", "gzip", large, []Option{MaximumBodySize(1024)}, 0}, // compresses well below the limit
+		{"application/json", "gzip", large, []Option{MaximumDecodedSize(1024)}, http.StatusRequestEntityTooLarge},
+		{"application/json", "zstd", large, []Option{MaximumDecodedSize(1024)}, http.StatusRequestEntityTooLarge},
+		{"application/json", "deflate", large, []Option{MaximumDecodedSize(1024)}, http.StatusRequestEntityTooLarge},
+		{"application/json", "zstd", huge, []Option{MaximumDecodedSize(0)}, 0}, // zero disables the limit, rather than applying the default
+		{"application/x-www-form-urlencoded", "", form, nil, 0},
+		{"application/x-www-form-urlencoded", "gzip", form, nil, 0},
+		{"application/x-www-form-urlencoded", "", largeForm, []Option{MaximumBodySize(1024)}, http.StatusRequestEntityTooLarge},
+		{mtype, "", mpart, nil, 0},
+		{largeMtype, "", largeMpart, []Option{MaximumBodySize(1024)}, http.StatusRequestEntityTooLarge},
+	}
+	for _, e := range tests {
+		var v testEntity
+		req := mustReq(e.Type, e.Encoding, compress(e.Encoding, e.Data))
+		err := Unmarshal(req, &v, e.Opts...)
+		if e.Status != 0 {
+			assert.Equal(t, e.Status, statusOf(err), "%s %s: %v", e.Type, e.Encoding, err)
+		} else if assert.NoError(t, err, "%s %s", e.Type, e.Encoding) {
+			assert.Equal(t, 1, v.B)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		Type   string
+		Data   string
+		Status int
+		Code   resterrs.Code
+		Detail map[string]interface{}
+	}{
+		{"text/plain", "Hello", http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, map[string]interface{}{"supported": newConfig(nil).supportedMimetypes()}},
+		{"", "Hello", http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, map[string]interface{}{"supported": newConfig(nil).supportedMimetypes()}},
+		{"application/json", "", http.StatusBadRequest, CodeMalformedEntity, nil},
+		{"application/json", "{\n  \"a\": \"Hello\",\n  \"b\": x\n}", http.StatusBadRequest, CodeMalformedEntity, map[string]interface{}{"offset": int64(26), "line": 3, "column": 8, "field_errors": validate.Errors{validate.FieldErrorf("", "invalid character 'x' looking for beginning of value")}}},
+		{"application/json", "{\"a\": \"Hello\", \"b\": \"1\"}", http.StatusBadRequest, CodeMalformedEntity, map[string]interface{}{"offset": int64(23), "line": 1, "column": 23, "field": "b", "field_errors": validate.Errors{validate.FieldErrorf("b", "Expected number, found string")}}},
+		{"application/json", "{\"a\": \"Hello\"", http.StatusBadRequest, CodeMalformedEntity, map[string]interface{}{"offset": int64(13), "line": 1, "column": 13, "field_errors": validate.Errors{validate.FieldErrorf("", "Unexpected end of entity")}}},
+		{"application/x-www-form-urlencoded", "a=Hello&b=x", http.StatusBadRequest, CodeMalformedEntity, map[string]interface{}{"field_errors": validate.Errors{validate.FieldErrorf("b", "Expected int")}}},
+	}
+	for _, e := range tests {
+		var v testEntity
+		err := Unmarshal(mustReq(e.Type, "", []byte(e.Data)), &v)
+		resterr, ok := err.(*resterrs.Error)
+		if assert.True(t, ok, "%s: %v", e.Data, err) {
+			assert.Equal(t, e.Status, resterr.Status, e.Data)
+			assert.Equal(t, e.Code, resterr.Code, e.Data)
+			assert.Equal(t, e.Detail, resterr.Detail, e.Data)
+		}
+	}
+
+	err := Unmarshal(mustReq("text/plain", "", nil), &testEntity{})
+	assert.ErrorIs(t, err, ErrUnsupportedMimetype)
+	err = Unmarshal(mustReq("application/json", "br", nil), &testEntity{})
+	assert.Equal(t, CodeUnsupportedEncoding, err.(*resterrs.Error).Code)
+	err = Unmarshal(mustReq("application/json", "", []byte(`{}`)), &testEntity{}, MaximumBodySize(1))
+	assert.Equal(t, CodeEntityTooLarge, err.(*resterrs.Error).Code)
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	type nested struct {
+		C struct {
+			D []int `json:"d"`
+		} `json:"c"`
+	}
+	tests := []struct {
+		Data   string
+		Opts   []Option
+		Detail map[string]interface{}
+	}{
+		{`{"a":"Hello","b":1,"z":true}`, nil, nil},
+		{`{"a":"Hello","b":1} trailing`, nil, nil},
+		{`{"a":"Hello","b":1,"z":true}`, []Option{DisallowUnknownFields()}, map[string]interface{}{"field": "z", "field_errors": validate.Errors{validate.FieldErrorf("z", "Unknown field")}}},
+		{`{"a":"Hello","b":1} {}`, []Option{DisallowTrailingData()}, map[string]interface{}{"offset": int64(20), "line": 1, "column": 21, "field_errors": validate.Errors{validate.FieldErrorf("", "Unexpected data after entity")}}},
+		{"{\"a\":\"Hello\",\"b\":1}\n\n  trailing", []Option{Strict()}, map[string]interface{}{"offset": int64(23), "line": 3, "column": 3, "field_errors": validate.Errors{validate.FieldErrorf("", "Unexpected data after entity")}}},
+		{"{\"a\":\"Hello\",\"b\":1}\n\n", []Option{Strict()}, nil},
+	}
+	for _, e := range tests {
+		var v testEntity
+		err := Unmarshal(mustReq("application/json", "", []byte(e.Data)), &v, e.Opts...)
+		if e.Detail == nil {
+			assert.NoError(t, err, e.Data)
+			continue
+		}
+		if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%s: %v", e.Data, err) {
+			assert.Equal(t, http.StatusBadRequest, resterr.Status, e.Data)
+			assert.Equal(t, e.Detail, resterr.Detail, e.Data)
+		}
+	}
+
+	// field paths of nested type errors are reported
+	var n nested
+	err := Unmarshal(mustReq("application/json", "", []byte(`{"c":{"d":[1,"two"]}}`)), &n)
+	if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, validate.Errors{validate.FieldErrorf("c.d[1]", "Expected number, found string")}, resterr.Detail["field_errors"])
+	}
+
+	// numbers are decoded as json.Number
+	var m map[string]interface{}
+	err = Unmarshal(mustReq("application/json", "", []byte(`{"n":12345678901234567890}`)), &m, UseNumber())
+	if assert.NoError(t, err) {
+		assert.Equal(t, json.Number("12345678901234567890"), m["n"])
+	}
+}
+
+type codecEntity struct {
+	Name  string `json:"name" xml:"name" yaml:"name"`
+	Count int    `json:"count" xml:"count" yaml:"count"`
+}
+
+func TestUnmarshalCodecs(t *testing.T) {
+	expect := codecEntity{Name: "Hello", Count: 3}
+	mbuf := &bytes.Buffer{}
+	menc := msgpack.NewEncoder(mbuf)
+	menc.SetCustomStructTag("json")
+	assert.NoError(t, menc.Encode(expect))
+	mpack := mbuf.Bytes()
+	cbd, err := cbor.Marshal(expect)
+	assert.NoError(t, err)
+	upper := DecoderFunc(func(data []byte, entity interface{}) error {
+		entity.(*codecEntity).Name = strings.ToUpper(string(data))
+		return nil
+	})
+
+	tests := []struct {
+		Type   string
+		Data   []byte
+		Opts   []Option
+		Expect codecEntity
+		Code   resterrs.Code
+	}{
+		{"application/json", []byte(`{"name":"Hello","count":3}`), nil, expect, ""},
+		{"application/problem+json", []byte(`{"name":"Hello","count":3}`), nil, expect, ""},
+		{"application/xml", []byte(`<entity><name>Hello</name><count>3</count></entity>`), nil, expect, ""},
+		{"application/atom+xml; charset=utf-8", []byte(`<entity><name>Hello</name><count>3</count></entity>`), nil, expect, ""},
+		{"text/xml", []byte(`<entity><name>Hello</name><count>3</count><other/></entity>`), []Option{Strict()}, expect, ""},
+		{"application/yaml", []byte("name: Hello\ncount: 3\n"), nil, expect, ""},
+		{"application/x-yaml", []byte("name: Hello\ncount: 3\nother: 1\n"), nil, expect, ""},
+		{"application/msgpack", mpack, nil, expect, ""},
+		{"application/cbor", cbd, nil, expect, ""},
+		{"application/xml", []byte(`<entity><name>Hello</name><count>3</count></entity><!-- end -->`), []Option{DisallowTrailingData()}, expect, ""},
+		{"application/yaml", []byte("name: Hello\ncount: 3\n---\nname: Other\n"), nil, expect, ""},
+		{"application/msgpack", append(mpack, 0xc0), nil, expect, ""},
+		{"application/cbor", append(cbd, 0xf6), nil, expect, ""},
+		{"text/plain", []byte("hello"), []Option{WithDecoder("Text/Plain", upper)}, codecEntity{Name: "HELLO"}, ""},
+		{"application/xml", []byte(`<entity><name>Hello</name`), nil, codecEntity{}, CodeMalformedEntity},
+		{"application/yaml", []byte("name: [Hello"), nil, codecEntity{}, CodeMalformedEntity},
+		{"application/yaml", []byte("name: Hello\nother: 1\n"), []Option{Strict()}, codecEntity{}, CodeMalformedEntity},
+		{"application/msgpack", []byte{0xc1}, nil, codecEntity{}, CodeMalformedEntity},
+		{"application/cbor", []byte{0xff}, nil, codecEntity{}, CodeMalformedEntity},
+		{"application/cbor", []byte{}, nil, codecEntity{}, CodeMalformedEntity},
+		{"application/xml", []byte(`<entity><name>Hello</name><count>3</count></entity><other/>`), []Option{DisallowTrailingData()}, codecEntity{}, CodeMalformedEntity},
+		{"application/yaml", []byte("name: Hello\ncount: 3\n---\nname: Other\n"), []Option{DisallowTrailingData()}, codecEntity{}, CodeMalformedEntity},
+		{"application/msgpack", append(mpack, 0xc0), []Option{DisallowTrailingData()}, codecEntity{}, CodeMalformedEntity},
+		{"application/cbor", append(cbd, 0xf6), []Option{DisallowTrailingData()}, codecEntity{}, CodeMalformedEntity},
+		{"text/plain", []byte("hello"), nil, codecEntity{}, CodeUnsupportedMediaType},
+	}
+	for _, e := range tests {
+		var entity codecEntity
+		err := Unmarshal(mustReq(e.Type, "", e.Data), &entity, e.Opts...)
+		if e.Code != "" {
+			if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%s: %v", e.Type, err) {
+				assert.Equal(t, e.Code, resterr.Code, e.Type)
+			}
+			continue
+		}
+		if assert.NoError(t, err, e.Type) {
+			assert.Equal(t, e.Expect, entity, e.Type)
+		}
+	}
+
+	// binary entities which consist of whitespace bytes are not empty
+	var n int
+	err = Unmarshal(mustReq("application/msgpack", "", []byte{0x0a}), &n)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 10, n)
+	}
+	err = Unmarshal(mustReq("application/cbor", "", []byte{0x0a}), &n)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 10, n)
+	}
+
+	// registered decoders apply to every call, and are listed as supported
+	RegisterDecoder("application/x-upper", upper)
+	var entity codecEntity
+	err = Unmarshal(mustReq("application/x-upper", "", []byte("hello")), &entity)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "HELLO", entity.Name)
+	}
+	err = Unmarshal(mustReq("text/plain", "", []byte("hello")), &entity)
+	if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%v", err) {
+		assert.Contains(t, resterr.Detail["supported"], "application/x-upper")
+		assert.Contains(t, resterr.Detail["supported"], "*/*+json")
+	}
+}
+
+type uploadPart struct {
+	Field, Filename, Type string
+	Data                  []byte
+}
+
+func uploadBody(fields map[string]string, files []uploadPart) ([]byte, string) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	for _, e := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, e.Field, e.Filename))
+		h.Set("Content-Type", e.Type)
+		p, _ := w.CreatePart(h)
+		p.Write(e.Data)
+	}
+	w.Close()
+	return buf.Bytes(), w.FormDataContentType()
+}
+
+func TestUnmarshalMultipart(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 12)...)
+	text := []byte("Hello, this is text!")
+	sum := sha256.Sum256(png)
+
+	// fields are decoded and files are stored in memory
+	data, ctype := uploadBody(map[string]string{"a": "Hello", "b": "1"}, []uploadPart{
+		{"photo", "photo.png", "application/octet-stream", png},
+		{"doc", "doc.txt", "text/plain", text},
+	})
+	var entity testEntity
+	u, err := UnmarshalMultipart(mustReq(ctype, "", data), &entity, WithFileSink(NewMemorySink()), FileChecksums(ChecksumSHA256))
+	if assert.NoError(t, err) {
+		assert.Equal(t, testEntity{A: "Hello", B: 1}, entity)
+		if f := u.Get("photo"); assert.Len(t, f, 1) {
+			assert.Equal(t, "photo.png", f[0].Filename)
+			assert.Equal(t, "image/png", f[0].Type)
+			assert.Equal(t, "application/octet-stream", f[0].DeclaredType)
+			assert.Equal(t, int64(len(png)), f[0].Size)
+			assert.Equal(t, hex.EncodeToString(sum[:]), f[0].Checksums[ChecksumSHA256])
+			assert.Equal(t, png, f[0].Data)
+		}
+		if f := u.Get("doc"); assert.Len(t, f, 1) {
+			assert.Equal(t, "text/plain", f[0].Type)
+			assert.Equal(t, text, f[0].Data)
+		}
+	}
+
+	// files are stored in a directory under a safe name, and can be removed
+	dir := t.TempDir()
+	data, ctype = uploadBody(nil, []uploadPart{{"photo", "../../photo.png", "image/png", png}})
+	u, err = UnmarshalMultipart(mustReq(ctype, "", data), nil, WithFileSink(NewDirSink(dir)))
+	if assert.NoError(t, err) && assert.Len(t, u.Files, 1) {
+		f := u.Files[0]
+		assert.Equal(t, dir, filepath.Dir(f.Path))
+		assert.True(t, strings.HasSuffix(f.Path, "-photo.png"), f.Path)
+		r, err := f.Open()
+		if assert.NoError(t, err) {
+			stored, _ := io.ReadAll(r)
+			r.Close()
+			assert.Equal(t, png, stored)
+		}
+		path := f.Path
+		assert.NoError(t, u.Remove())
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	tests := []struct {
+		Fields map[string]string
+		Files  []uploadPart
+		Opts   []Option
+		Status int
+		Code   resterrs.Code
+	}{
+		{nil, []uploadPart{{"photo", "photo.png", "image/png", png}, {"doc", "doc.png", "image/png", text}}, []Option{AllowedFileTypes("image/*")}, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{nil, []uploadPart{{"doc", "doc.txt", "text/plain", text}}, []Option{MaximumFileSize(10)}, http.StatusRequestEntityTooLarge, CodeEntityTooLarge},
+		{nil, []uploadPart{{"photo", "photo.png", "image/png", png}, {"doc", "doc.txt", "text/plain", text}}, []Option{MaximumFileSize(20), MaximumUploadSize(30)}, http.StatusRequestEntityTooLarge, CodeEntityTooLarge},
+		{map[string]string{"a": strings.Repeat("x", 10)}, nil, []Option{MaximumMemory(4)}, http.StatusRequestEntityTooLarge, CodeEntityTooLarge},
+		{map[string]string{"b": "many"}, nil, nil, http.StatusBadRequest, CodeMalformedEntity},
+	}
+	for i, e := range tests {
+		dir := t.TempDir()
+		data, ctype := uploadBody(e.Fields, e.Files)
+		_, err := UnmarshalMultipart(mustReq(ctype, "", data), &testEntity{}, append(e.Opts, WithFileSink(NewDirSink(dir)))...)
+		if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "#%d: %v", i, err) {
+			assert.Equal(t, e.Status, resterr.Status, "#%d", i)
+			assert.Equal(t, e.Code, resterr.Code, "#%d", i)
+		}
+		stored, _ := os.ReadDir(dir)
+		assert.Len(t, stored, 0, "#%d: stored files must be removed on error", i)
+	}
+
+	// the memory limit applies to every field, including those which follow a
+	// field that exhausts it
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	w.WriteField("a", "Hell")
+	w.WriteField("b", "1")
+	w.Close()
+	_, err = UnmarshalMultipart(mustReq(w.FormDataContentType(), "", buf.Bytes()), &entity, MaximumMemory(4))
+	if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resterr.Status)
+		assert.Equal(t, CodeEntityTooLarge, resterr.Code)
+	}
+
+	// only multipart forms are supported
+	_, err = UnmarshalMultipart(mustReq("application/json", "", []byte(`{}`)), &entity)
+	assert.ErrorIs(t, err, ErrUnsupportedMimetype)
+}
+
+type validatedEntity struct {
+	Name  string `json:"name" check:"len(self) > 0" create:"len(self) > 0" invalid:"Name is required"`
+	Count int    `json:"count" check:"self >= 0" invalid:"Count must not be negative"`
+}
+
+type hookedEntity struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (e hookedEntity) Validate() error {
+	if e.End < e.Start {
+		return validate.FieldErrorf("end", "End must follow start")
+	}
+	return nil
+}
+
+type pointerHookedEntity struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (e *pointerHookedEntity) Validate() error {
+	if e.End < e.Start {
+		return validate.FieldErrorf("end", "End must follow start")
+	}
+	return nil
+}
+
+func TestUnmarshalAndValidate(t *testing.T) {
+	tests := []struct {
+		Data   string
+		Entity interface{}
+		Opts   []Option
+		Errors validate.Errors
+	}{
+		{`{"name":"Hello","count":1}`, &validatedEntity{}, nil, nil},
+		{`{"name":"","count":-1}`, &validatedEntity{}, nil, validate.Errors{validate.FieldErrorf("name", "Name is required"), validate.FieldErrorf("count", "Count must not be negative")}},
+		{`{"name":"","count":-1}`, &validatedEntity{}, []Option{ValidationGroup("create")}, validate.Errors{validate.FieldErrorf("name", "Name is required")}},
+		{`{"name":"","count":-1}`, &validatedEntity{}, []Option{ValidationGroup("update")}, nil},
+		{`{"start":1,"end":2}`, &hookedEntity{}, nil, nil},
+		{`{"start":2,"end":1}`, &hookedEntity{}, nil, validate.Errors{validate.FieldErrorf("end", "End must follow start")}},
+		{`{"start":1,"end":2}`, &pointerHookedEntity{}, nil, nil},
+		{`{"start":2,"end":1}`, &pointerHookedEntity{}, nil, validate.Errors{validate.FieldErrorf("end", "End must follow start")}},
+	}
+	for _, e := range tests {
+		err := UnmarshalAndValidate(mustReq("application/json", "", []byte(e.Data)), e.Entity, e.Opts...)
+		if e.Errors == nil {
+			assert.NoError(t, err, e.Data)
+			continue
+		}
+		if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%s: %v", e.Data, err) {
+			assert.Equal(t, http.StatusUnprocessableEntity, resterr.Status, e.Data)
+			assert.Equal(t, CodeInvalidEntity, resterr.Code, e.Data)
+			assert.Equal(t, e.Errors, resterr.Detail["field_errors"], e.Data)
+		}
+	}
+
+	// decoding errors are reported before validation
+	err := UnmarshalAndValidate(mustReq("application/json", "", []byte(`{"name":1}`)), &validatedEntity{})
+	assert.Equal(t, CodeMalformedEntity, err.(*resterrs.Error).Code)
+}
+
+type bindLevel int
+
+func (l *bindLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level: %s", text)
+	}
+	return nil
+}
+
+type bindPage struct {
+	Limit  int  `query:"limit" default:"10"`
+	Offset *int `query:"offset"`
+}
+
+type bindParams struct {
+	bindPage
+	ID      string        `path:"id"`
+	Tags    []string      `query:"tag,csv"`
+	Values  []float64     `query:"v"`
+	Since   time.Time     `query:"since" layout:"2006-01-02"`
+	Wait    time.Duration `query:"wait"`
+	Tenant  string        `header:"X-Tenant,required"`
+	Session string        `cookie:"session"`
+	Level   bindLevel     `query:"level"`
+	Ignored string        `query:"-"`
+}
+
+func TestBind(t *testing.T) {
+	offset := 5
+	tests := []struct {
+		URL     string
+		Header  map[string]string
+		Vars    map[string]string
+		Expect  bindParams
+		Code    resterrs.Code
+		Message string
+		Errors  validate.Errors
+	}{
+		{
+			URL:    "/things/abc?tag=a,b&tag=c&v=1.5&v=2&since=2024-05-01&wait=1m&offset=5&level=high&Ignored=x",
+			Header: map[string]string{"X-Tenant": "acme", "Cookie": "session=xyz"},
+			Vars:   map[string]string{"id": "abc"},
+			Expect: bindParams{
+				bindPage: bindPage{Limit: 10, Offset: &offset},
+				ID:       "abc",
+				Tags:     []string{"a", "b", "c"},
+				Values:   []float64{1.5, 2},
+				Since:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+				Wait:     time.Minute,
+				Tenant:   "acme",
+				Session:  "xyz",
+				Level:    2,
+			},
+		},
+		{
+			URL:     "/things?limit=20",
+			Code:    CodeMissingParameter,
+			Message: "Invalid header parameter: X-Tenant: Parameter is required",
+			Errors:  validate.Errors{validate.FieldErrorf("X-Tenant", "Parameter is required")},
+		},
+		{
+			URL:     "/things?limit=many&since=yesterday&level=medium",
+			Header:  map[string]string{"X-Tenant": "acme"},
+			Code:    CodeInvalidParameter,
+			Message: "Invalid query parameter: limit: Expected an integer",
+			Errors: validate.Errors{
+				validate.FieldErrorf("limit", "Expected an integer"),
+				validate.FieldErrorf("since", "Expected a time"),
+				validate.FieldErrorf("level", "Invalid value: unknown level: medium"),
+			},
+		},
+	}
+	for _, e := range tests {
+		req, err := http.NewRequest("GET", e.URL, nil)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		for k, v := range e.Header {
+			req.Header.Set(k, v)
+		}
+		var params bindParams
+		err = Bind((*router.Request)(req), router.Context{Vars: e.Vars}, &params)
+		if e.Code == "" {
+			if assert.NoError(t, err, e.URL) {
+				assert.Equal(t, e.Expect, params, e.URL)
+			}
+			continue
+		}
+		if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%s: %v", e.URL, err) {
+			assert.Equal(t, http.StatusBadRequest, resterr.Status, e.URL)
+			assert.Equal(t, e.Code, resterr.Code, e.URL)
+			assert.Equal(t, e.Message, resterr.Message, e.URL)
+			assert.Equal(t, e.Errors, resterr.Detail["field_errors"], e.URL)
+		}
+	}
+
+	// unsupported field types are programming errors, not bad requests
+	var bad struct {
+		C chan int `query:"c"`
+	}
+	req, _ := http.NewRequest("GET", "/?c=1", nil)
+	err := Bind((*router.Request)(req), router.Context{}, &bad)
+	if assert.Error(t, err) {
+		assert.ErrorIs(t, err, errUnsupportedBindType)
+	}
+	assert.Error(t, Bind((*router.Request)(req), router.Context{}, bad))
+}
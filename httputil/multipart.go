@@ -0,0 +1,285 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+)
+
+// Checksum algorithms which may be calculated for uploaded files
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+)
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	ChecksumMD5:    md5.New,
+	ChecksumSHA1:   sha1.New,
+	ChecksumSHA256: sha256.New,
+	ChecksumSHA512: sha512.New,
+}
+
+// The amount of file content used to sniff its type
+const sniffLen = 512
+
+var (
+	errFileTooLarge   = errors.New("File is too large")
+	errFieldsTooLarge = errors.New("Form fields are too large")
+)
+
+// The files received in a multipart request
+type Uploads struct {
+	Files []*File
+	sink  FileSink
+}
+
+// Obtain the files received for a form field
+func (u *Uploads) Get(field string) []*File {
+	var res []*File
+	for _, e := range u.Files {
+		if e.Field == field {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Remove every file from the sink which stored it
+func (u *Uploads) Remove() error {
+	var errs []error
+	for _, e := range u.Files {
+		if err := u.sink.Remove(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Unmarshal a multipart/form-data request by streaming its parts, rather than
+// buffering the entire form as Unmarshal does. Non-file fields are decoded
+// into the entity, which may be nil if there are none of interest, and file
+// parts are stored, as they are read, in the configured FileSink; the
+// default sink stores them in the temporary directory. The caller is
+// responsible for removing stored files once they have been handled.
+//
+// The type of each file is determined by sniffing its content, and it is
+// checked against the allowed file types, if any; the type declared by the
+// client is recorded but not trusted. Per-file and total size limits are
+// enforced as files are read, as is the maximum memory, which bounds the
+// size of non-file fields. Checksums of file content are calculated if
+// requested.
+//
+// Errors are *resterrs.Error values, as with Unmarshal: 415 for a file type
+// which is not allowed, 413 for a file or form which exceeds a limit, and 400
+// for a malformed form. If an error occurs, any files already stored are
+// removed.
+func UnmarshalMultipart(req *router.Request, entity interface{}, opts ...Option) (*Uploads, error) {
+	return UnmarshalMultipartWithConfig(req, entity, newConfig(opts))
+}
+
+// Unmarshal a multipart/form-data request by streaming its parts, as with
+// UnmarshalMultipart, with the provided configuration
+func UnmarshalMultipartWithConfig(req *router.Request, entity interface{}, conf Config) (*Uploads, error) {
+	for _, e := range conf.FileChecksums {
+		if _, ok := checksumAlgorithms[e]; !ok {
+			return nil, fmt.Errorf("Unsupported checksum algorithm: %s", e)
+		}
+	}
+
+	ctype := req.Header.Get("Content-Type")
+	m, params, err := mime.ParseMediaType(ctype)
+	if err != nil || !strings.EqualFold(m, "multipart/form-data") || params["boundary"] == "" {
+		return nil, resterrs.New(http.StatusUnsupportedMediaType, "Unsupported content type: "+ctype, ErrUnsupportedMimetype).
+			SetCode(CodeUnsupportedMediaType).
+			AddDetail(map[string]interface{}{"supported": []string{"multipart/form-data"}})
+	}
+	release, err := prepareBody(req, conf)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	sink := conf.FileSink
+	if sink == nil {
+		sink = NewTempSink()
+	}
+	u := &Uploads{sink: sink}
+	vals, err := readParts(multipart.NewReader(req.Body, params["boundary"]), u, conf)
+	if err == nil && entity != nil {
+		if derr := formDecoder.Decode(entity, vals); derr != nil {
+			err = errMalformedForm(derr)
+		}
+	}
+	if err != nil {
+		u.Remove()
+		return nil, err
+	}
+	return u, nil
+}
+
+// Iterate the parts of a multipart form, storing files and collecting the
+// values of other fields
+func readParts(mr *multipart.Reader, u *Uploads, conf Config) (url.Values, error) {
+	vals := make(url.Values)
+	fieldsMem := conf.MaxMem
+	var total int64
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return vals, nil
+		} else if err != nil {
+			return nil, errMultipartRead(err)
+		}
+
+		if part.FileName() == "" {
+			var r io.Reader = part
+			if conf.MaxMem > 0 {
+				r = &limitReader{part, fieldsMem, errFieldsTooLarge}
+			}
+			data, err := io.ReadAll(r)
+			part.Close()
+			if err != nil {
+				return nil, errMultipartRead(err)
+			}
+			fieldsMem -= int64(len(data))
+			vals.Add(part.FormName(), string(data))
+			continue
+		}
+
+		f, err := readFile(part, u.sink, conf, total)
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		total += f.Size
+		u.Files = append(u.Files, f)
+	}
+}
+
+// Sniff, check and store a file part
+func readFile(part *multipart.Part, sink FileSink, conf Config, total int64) (*File, error) {
+	f := &File{
+		Field:        part.FormName(),
+		Filename:     part.FileName(),
+		DeclaredType: part.Header.Get("Content-Type"),
+	}
+	detail := map[string]interface{}{"field": f.Field, "filename": f.Filename}
+
+	limit := conf.MaxFileSize
+	if conf.MaxUploadSize > 0 && (limit <= 0 || conf.MaxUploadSize-total < limit) {
+		limit = conf.MaxUploadSize - total
+	}
+	src := &readErrRecorder{r: part}
+	var r io.Reader = src
+	if limit > 0 || conf.MaxUploadSize > 0 {
+		r = &limitReader{src, max(limit, 0), errFileTooLarge}
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, errFileRead(err, detail)
+	}
+	head = head[:n]
+	f.Type, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	if !fileTypeAllowed(f.Type, conf.AllowedFileTypes) {
+		return nil, resterrs.Errorf(http.StatusUnsupportedMediaType, "Unsupported file type: %s", f.Type).
+			SetCode(CodeUnsupportedMediaType).
+			AddDetail(detail).
+			AddDetail(map[string]interface{}{"type": f.Type, "allowed": conf.AllowedFileTypes})
+	}
+
+	var size sizeWriter
+	hashes := make(map[string]hash.Hash)
+	writers := []io.Writer{&size}
+	for _, e := range conf.FileChecksums {
+		h := checksumAlgorithms[e]()
+		hashes[e] = h
+		writers = append(writers, h)
+	}
+	content := io.TeeReader(io.MultiReader(bytes.NewReader(head), r), io.MultiWriter(writers...))
+	if err := sink.Store(f, content); err != nil {
+		if src.err != nil || errors.Is(err, errFileTooLarge) {
+			return nil, errFileRead(err, detail)
+		}
+		return nil, resterrs.New(http.StatusInternalServerError, "Could not store file", err).AddDetail(detail)
+	}
+	f.Size = int64(size)
+
+	if len(hashes) > 0 {
+		f.Checksums = make(map[string]string, len(hashes))
+		for k, h := range hashes {
+			f.Checksums[k] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	return f, nil
+}
+
+// Determine if a file type is allowed; an empty list allows every type
+func fileTypeAllowed(t string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	major, _, _ := strings.Cut(t, "/")
+	for _, e := range allowed {
+		if strings.EqualFold(e, t) || strings.EqualFold(e, major+"/*") {
+			return true
+		}
+	}
+	return false
+}
+
+func errMultipartRead(err error) *resterrs.Error {
+	if tooLarge(err) || errors.Is(err, errFieldsTooLarge) {
+		return errTooLarge(err)
+	}
+	return errMalformed("Could not parse multipart form", err)
+}
+
+func errFileRead(err error, detail map[string]interface{}) *resterrs.Error {
+	if errors.Is(err, errFileTooLarge) {
+		return errTooLarge(err).AddDetail(detail)
+	}
+	return errMultipartRead(err).AddDetail(detail)
+}
+
+// Records the first error, other than EOF, produced by a reader, so that a
+// failure to read a file can be distinguished from a failure to store it
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// Counts the bytes written to it
+type sizeWriter int64
+
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	*w += sizeWriter(len(p))
+	return len(p), nil
+}
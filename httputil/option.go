@@ -1,7 +1,29 @@
 package httputil
 
+import (
+	"strings"
+
+	"github.com/bww/go-validate/v1"
+)
+
 type Config struct {
-	MaxMem int64
+	MaxMem     int64
+	MaxBody    int64
+	MaxDecoded int64
+
+	DisallowUnknownFields bool
+	DisallowTrailingData  bool
+	UseNumber             bool
+
+	Validation []validate.Option
+
+	Decoders map[string]Decoder
+
+	FileSink         FileSink
+	MaxFileSize      int64
+	MaxUploadSize    int64
+	AllowedFileTypes []string
+	FileChecksums    []string
 }
 
 func (c Config) WithOptions(opts []Option) Config {
@@ -22,3 +44,143 @@ func MaximumMemory(v int64) Option {
 		return c
 	}
 }
+
+// The maximum size of a request entity, as transmitted. A request with a
+// larger entity is rejected with 413 Request Entity Too Large. This applies
+// to every entity type, including forms and multipart data. Zero disables
+// the limit.
+func MaximumBodySize(v int64) Option {
+	return func(c Config) Config {
+		c.MaxBody = v
+		return c
+	}
+}
+
+// The maximum size of a compressed request entity once it is decoded. This
+// protects against small entities which decompress to enormous sizes. A
+// request which exceeds it is rejected with 413 Request Entity Too Large.
+// Zero disables the limit.
+func MaximumDecodedSize(v int64) Option {
+	return func(c Config) Config {
+		c.MaxDecoded = v
+		return c
+	}
+}
+
+// Reject entities which contain fields that do not correspond to a field in
+// the destination entity. This applies to JSON, YAML, MessagePack and CBOR;
+// XML decoding always ignores unknown elements.
+func DisallowUnknownFields() Option {
+	return func(c Config) Config {
+		c.DisallowUnknownFields = true
+		return c
+	}
+}
+
+// Reject entities which are followed by anything other than whitespace. For
+// YAML this requires a single document, and for binary formats, like
+// MessagePack and CBOR, no data at all may follow the entity. By default,
+// data which follows an entity is ignored.
+func DisallowTrailingData() Option {
+	return func(c Config) Config {
+		c.DisallowTrailingData = true
+		return c
+	}
+}
+
+// Decode JSON numbers into interface{} values as json.Number instead of
+// float64, which preserves their precision
+func UseNumber() Option {
+	return func(c Config) Config {
+		c.UseNumber = true
+		return c
+	}
+}
+
+// Strict JSON decoding, which disallows both unknown fields and trailing data
+func Strict() Option {
+	return func(c Config) Config {
+		c.DisallowUnknownFields = true
+		c.DisallowTrailingData = true
+		return c
+	}
+}
+
+// Decode entities of a media type with the provided decoder, in preference to
+// any registered decoder. As with RegisterDecoder, the media type may be a
+// structured syntax suffix, like "+json".
+func WithDecoder(mimetype string, dec Decoder) Option {
+	return func(c Config) Config {
+		decs := make(map[string]Decoder, len(c.Decoders)+1)
+		for k, v := range c.Decoders {
+			decs[k] = v
+		}
+		decs[strings.ToLower(mimetype)] = dec
+		c.Decoders = decs
+		return c
+	}
+}
+
+// The sink which stores the files received by UnmarshalMultipart. The
+// default stores files in the temporary directory.
+func WithFileSink(v FileSink) Option {
+	return func(c Config) Config {
+		c.FileSink = v
+		return c
+	}
+}
+
+// The maximum size of each file received by UnmarshalMultipart. A request
+// with a larger file is rejected with 413 Request Entity Too Large. Zero
+// disables the limit.
+func MaximumFileSize(v int64) Option {
+	return func(c Config) Config {
+		c.MaxFileSize = v
+		return c
+	}
+}
+
+// The maximum total size of the files received by UnmarshalMultipart. A
+// request whose files are larger in total is rejected with 413 Request
+// Entity Too Large. Zero disables the limit.
+func MaximumUploadSize(v int64) Option {
+	return func(c Config) Config {
+		c.MaxUploadSize = v
+		return c
+	}
+}
+
+// The file types accepted by UnmarshalMultipart, as determined by sniffing
+// their content. A type may be a wildcard, like "image/*". A request with a
+// file of any other type is rejected with 415 Unsupported Media Type. If no
+// types are provided, every type is accepted.
+func AllowedFileTypes(v ...string) Option {
+	return func(c Config) Config {
+		c.AllowedFileTypes = append(append([]string(nil), c.AllowedFileTypes...), v...)
+		return c
+	}
+}
+
+// The checksums calculated for each file received by UnmarshalMultipart; see
+// ChecksumSHA256 and the other supported algorithms
+func FileChecksums(v ...string) Option {
+	return func(c Config) Config {
+		c.FileChecksums = append(append([]string(nil), c.FileChecksums...), v...)
+		return c
+	}
+}
+
+// Options which configure the validator used by UnmarshalAndValidate
+func WithValidation(opts ...validate.Option) Option {
+	return func(c Config) Config {
+		c.Validation = append(c.Validation, opts...)
+		return c
+	}
+}
+
+// The validation group used by UnmarshalAndValidate; this selects the tag
+// from which validation checks are read, so that an entity may be validated
+// differently depending on the operation. The default is "check".
+func ValidationGroup(name string) Option {
+	return WithValidation(validate.Mode(name))
+}
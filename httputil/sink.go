@@ -0,0 +1,127 @@
+package httputil
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A file received in a multipart request
+type File struct {
+	Field        string            // the form field which carried the file
+	Filename     string            // the filename provided by the client; this is untrusted
+	DeclaredType string            // the content type declared by the client; this is untrusted
+	Type         string            // the content type determined by sniffing the file content
+	Size         int64             // the size of the file, in bytes
+	Checksums    map[string]string // hex-encoded checksums of the content, by algorithm
+	Path         string            // where the file is stored, for sinks which store files on disk
+	Data         []byte            // the file content, for sinks which store files in memory
+}
+
+// Open the stored file content for reading
+func (f *File) Open() (io.ReadCloser, error) {
+	if f.Path == "" {
+		return io.NopCloser(bytes.NewReader(f.Data)), nil
+	}
+	return os.Open(f.Path)
+}
+
+// A FileSink stores the files received in a multipart request
+type FileSink interface {
+	// Store a file by reading its content from r. The sink records where the
+	// file is stored in f. If an error occurs, including one produced by
+	// reading r, the sink discards whatever it has stored and returns it.
+	Store(f *File, r io.Reader) error
+	// Remove a file that was stored
+	Remove(f *File) error
+}
+
+// Produce a sink which stores files in the temporary directory. Stored files
+// should be removed once they have been handled.
+func NewTempSink() FileSink {
+	return dirSink{pattern: func(*File) string { return "upload-*" }}
+}
+
+// Produce a sink which stores files in a directory. Each file is named for
+// the filename the client provided, with unsafe characters replaced and a
+// random prefix to avoid collisions.
+func NewDirSink(dir string) FileSink {
+	return dirSink{dir: dir, pattern: func(f *File) string { return "*-" + safeFilename(f.Filename) }}
+}
+
+type dirSink struct {
+	dir     string
+	pattern func(*File) string
+}
+
+func (s dirSink) Store(f *File, r io.Reader) error {
+	dst, err := os.CreateTemp(s.dir, s.pattern(f))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	f.Path = dst.Name()
+	return nil
+}
+
+func (s dirSink) Remove(f *File) error {
+	if f.Path == "" {
+		return nil
+	}
+	err := os.Remove(f.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f.Path = ""
+	return nil
+}
+
+// Produce a sink which keeps files in memory. Use the file size limits to
+// bound the memory this may consume.
+func NewMemorySink() FileSink {
+	return memorySink{}
+}
+
+type memorySink struct{}
+
+func (s memorySink) Store(f *File, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.Data = data
+	return nil
+}
+
+func (s memorySink) Remove(f *File) error {
+	f.Data = nil
+	return nil
+}
+
+// Reduce a client-provided filename to one which is safe to use as the last
+// element of a path
+func safeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
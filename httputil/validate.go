@@ -0,0 +1,67 @@
+package httputil
+
+import (
+	"net/http"
+	"reflect"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+	"github.com/bww/go-validate/v1"
+)
+
+// Unmarshal a request entity, as with Unmarshal, and then validate it. If the
+// entity is invalid, a 422 error is returned which carries the validation
+// errors as field errors.
+//
+// Entities are validated by checks defined in their struct tags. A type may
+// also perform its own validation by implementing a Validate() error method,
+// with either a value or a pointer receiver; this is the validator's
+// IntrospectorV1 interface. The validator's other introspection interfaces
+// are only honored when they are implemented with a value receiver.
+//
+// Use ValidationGroup to select the checks which apply to a particular call,
+// for example, to validate differently on create and update.
+func UnmarshalAndValidate(req *router.Request, entity interface{}, opts ...Option) error {
+	conf := newConfig(opts)
+	err := UnmarshalWithConfig(req, entity, conf)
+	if err != nil {
+		return err
+	}
+	return validateEntity(entity, conf)
+}
+
+func validateEntity(entity interface{}, conf Config) error {
+	errs := validate.New(conf.Validation...).Validate(validationTarget(entity))
+	if len(errs) > 0 {
+		return resterrs.New(http.StatusUnprocessableEntity, "Invalid request entity", errs).
+			SetCode(CodeInvalidEntity).
+			SetFieldErrors(errs)
+	}
+	return nil
+}
+
+var introspectorV1Type = reflect.TypeOf((*validate.IntrospectorV1)(nil)).Elem()
+
+// The validator only considers the methods of the value an entity points to,
+// so a Validate() error method with a pointer receiver would be skipped. Such
+// an entity is validated through an adapter which the validator recognizes.
+func validationTarget(entity interface{}) interface{} {
+	hook, ok := entity.(validate.IntrospectorV1)
+	if !ok {
+		return entity
+	}
+	v := reflect.ValueOf(entity)
+	if v.Kind() == reflect.Pointer && !v.Elem().Type().Implements(introspectorV1Type) {
+		return validateHook{hook}
+	}
+	return entity
+}
+
+type validateHook struct {
+	hook validate.IntrospectorV1
+}
+
+func (h validateHook) Validate() error {
+	return h.hook.Validate()
+}
@@ -0,0 +1,101 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bww/go-metrics/v1"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Route labels used for requests which were not handled by a route. These
+// are handled by the default handler, if there is one.
+const (
+	routeLabelNotFound = "(not found)"
+	routeLabelError    = "(error)"
+)
+
+// Methods which are labeled as themselves; others are labeled as OTHER in
+// order to bound the cardinality of metrics
+var labeledMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodPost:    {},
+	http.MethodPut:     {},
+	http.MethodPatch:   {},
+	http.MethodDelete:  {},
+	http.MethodConnect: {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+}
+
+func methodLabel(m string) string {
+	if _, ok := labeledMethods[m]; ok {
+		return m
+	}
+	return "OTHER"
+}
+
+// Service metrics. Requests are labeled by method and the pattern of the
+// matched route, never by the raw path, so that cardinality is bounded. Sizes
+// are sampled as summaries, which is what the metrics package provides.
+type serviceMetrics struct {
+	requests metrics.SamplerVec // request duration, in nanoseconds
+	inflight metrics.Gauge
+	reqsize  metrics.SamplerVec
+	rspsize  metrics.SamplerVec
+	panics   metrics.CounterVec
+	errors   metrics.CounterVec
+	handler  http.Handler // serves the registry the metrics are registered in
+}
+
+func newServiceMetrics(m *metrics.Metrics) *serviceMetrics {
+	return &serviceMetrics{
+		requests: m.RegisterSamplerVec("rest_request", "Request sampler", []string{"method", "route", "status"}),
+		inflight: m.RegisterGauge("rest_requests_in_flight", "Requests currently being handled", nil),
+		reqsize:  m.RegisterSamplerVec("rest_request_size", "Request entity size, in bytes", []string{"method", "route"}),
+		rspsize:  m.RegisterSamplerVec("rest_response_size", "Response entity size, in bytes", []string{"method", "route", "status"}),
+		panics:   m.RegisterCounterVec("rest_panics", "Panics recovered while handling requests", []string{"method", "route"}),
+		errors:   m.RegisterCounterVec("rest_errors", "Errors returned by handlers", []string{"method", "route"}),
+		// the metrics package registers everything in the default registry
+		handler: promhttp.Handler(),
+	}
+}
+
+// A request observation
+type requestSample struct {
+	Method   string
+	Route    string
+	Status   int
+	Duration float64
+	ReqSize  int64
+	RspSize  int64
+}
+
+func (m *serviceMetrics) Observe(v requestSample) {
+	method, status := methodLabel(v.Method), fmt.Sprint(v.Status)
+	m.requests.With(metrics.Tags{"method": method, "route": v.Route, "status": status}).Observe(v.Duration)
+	m.reqsize.With(metrics.Tags{"method": method, "route": v.Route}).Observe(float64(v.ReqSize))
+	m.rspsize.With(metrics.Tags{"method": method, "route": v.Route, "status": status}).Observe(float64(v.RspSize))
+}
+
+func (m *serviceMetrics) Panic(method, route string) {
+	m.panics.With(metrics.Tags{"method": methodLabel(method), "route": route}).Inc()
+}
+
+func (m *serviceMetrics) Error(method, route string) {
+	m.errors.With(metrics.Tags{"method": methodLabel(method), "route": route}).Inc()
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,171 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const defaultRedactionReplacement = "[REDACTED]"
+
+// A redaction policy describes sensitive data which is removed from debug
+// dumps. Names are matched case-insensitively.
+type Redaction struct {
+	Headers     []string // header names whose values are redacted
+	Params      []string // query and form parameter names whose values are redacted
+	Fields      []string // JSON object fields, at any depth, whose values are redacted
+	Replacement string   // the value substituted for redacted data; the default is "[REDACTED]"
+}
+
+// The default redaction policy, which covers commonly used credentials
+var DefaultRedaction = Redaction{
+	Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Csrf-Token"},
+	Params:  []string{"access_token", "refresh_token", "token", "api_key", "password", "secret"},
+	Fields:  []string{"password", "secret", "token", "access_token", "refresh_token", "api_key", "client_secret"},
+}
+
+type redactor struct {
+	headers map[string]struct{}
+	params  map[string]struct{}
+	fields  map[string]struct{}
+	pattern *regexp.Regexp
+	replace string
+}
+
+func newRedactor(conf Redaction) *redactor {
+	r := &redactor{
+		headers: nameSet(conf.Headers),
+		params:  nameSet(conf.Params),
+		fields:  nameSet(conf.Fields),
+		replace: conf.Replacement,
+	}
+	if r.replace == "" {
+		r.replace = defaultRedactionReplacement
+	}
+	if len(conf.Fields) > 0 {
+		// used to redact string fields in JSON documents that cannot be parsed,
+		// which is generally the case when they have been truncated
+		alts := make([]string, len(conf.Fields))
+		for i, e := range conf.Fields {
+			alts[i] = regexp.QuoteMeta(e)
+		}
+		r.pattern = regexp.MustCompile(`(?i)("(?:` + strings.Join(alts, "|") + `)"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+	}
+	return r
+}
+
+func nameSet(n []string) map[string]struct{} {
+	s := make(map[string]struct{})
+	for _, e := range n {
+		s[strings.ToLower(e)] = struct{}{}
+	}
+	return s
+}
+
+func (r *redactor) redacted(set map[string]struct{}, name string) bool {
+	_, ok := set[strings.ToLower(name)]
+	return ok
+}
+
+// Produce a copy of a header with sensitive values redacted
+func (r *redactor) Header(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	c := h.Clone()
+	for k, v := range c {
+		if r.redacted(r.headers, k) {
+			for i := range v {
+				v[i] = r.replace
+			}
+		}
+	}
+	return c
+}
+
+// Produce a string representation of a URL with sensitive parameters redacted
+func (r *redactor) URL(u *url.URL) string {
+	if u.RawQuery == "" || len(r.params) == 0 {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = r.values(u.Query(), r.params).Encode()
+	return c.String()
+}
+
+func (r *redactor) values(v url.Values, names ...map[string]struct{}) url.Values {
+	for k, e := range v {
+		for _, n := range names {
+			if r.redacted(n, k) {
+				for i := range e {
+					e[i] = r.replace
+				}
+				break
+			}
+		}
+	}
+	return v
+}
+
+// Redact sensitive data from an entity
+func (r *redactor) Entity(mtype string, data []byte, truncated bool) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	m, _, err := mime.ParseMediaType(mtype)
+	if err != nil {
+		return data
+	}
+	switch {
+	case m == "application/json" || strings.HasSuffix(m, "+json"):
+		return r.json(data, truncated)
+	case m == "application/x-www-form-urlencoded":
+		v, err := url.ParseQuery(string(data))
+		if err != nil {
+			return data
+		}
+		return []byte(r.values(v, r.params, r.fields).Encode())
+	default:
+		return data
+	}
+}
+
+func (r *redactor) json(data []byte, truncated bool) []byte {
+	if len(r.fields) == 0 {
+		return data
+	}
+	if !truncated {
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err == nil {
+			if red, err := json.Marshal(r.walk(doc)); err == nil {
+				return red
+			}
+		}
+	}
+	// fall back to redacting what we can match in the raw document
+	return r.pattern.ReplaceAll(data, []byte(`${1}"`+strings.ReplaceAll(r.replace, "$", "$$")+`"`))
+}
+
+func (r *redactor) walk(v interface{}) interface{} {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		for k, e := range c {
+			if r.redacted(r.fields, k) {
+				c[k] = r.replace
+			} else {
+				c[k] = r.walk(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range c {
+			c[i] = r.walk(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"github.com/google/uuid"
+)
+
+const defaultRequestIDHeader = "X-Request-Id"
+
+// The maximum length of an inbound request ID which will be accepted
+const maxRequestIDLength = 128
+
+// Produce a new request ID
+func newRequestID() string {
+	return uuid.New().String()
+}
+
+// Determine if an inbound request ID is acceptable. Request IDs provided by
+// the client are included in logs and echoed in responses, so we only accept
+// reasonably short values made up of a conservative set of characters.
+func validRequestID(v string) bool {
+	if l := len(v); l < 1 || l > maxRequestIDLength {
+		return false
+	}
+	for _, c := range v {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -3,12 +3,15 @@ package response
 import (
 	"html/template"
 	"net/http"
+	"strings"
 )
 
 type Config struct {
 	Status int
 	Header http.Header
 	Funcs  template.FuncMap
+
+	Encoders []Encoding
 }
 
 func (c Config) WithOptions(opts []Option) Config {
@@ -55,3 +58,13 @@ func WithFuncs(f template.FuncMap) Option {
 		return conf
 	}
 }
+
+// Offer an encoder for a media type to Negotiate, in preference to the
+// registered encoders. Encoders offered by this option are preferred in the
+// order they are provided.
+func WithEncoder(mimetype string, enc Encoder) Option {
+	return func(c Config) Config {
+		c.Encoders = append(append([]Encoding(nil), c.Encoders...), Encoding{strings.ToLower(mimetype), enc})
+		return c
+	}
+}
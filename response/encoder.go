@@ -0,0 +1,264 @@
+package response
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Media types with a built-in encoder
+const (
+	MimetypeJSON    = "application/json"
+	MimetypeXML     = "application/xml"
+	MimetypeYAML    = "application/yaml"
+	MimetypeMsgpack = "application/msgpack"
+	MimetypeCSV     = "text/csv"
+)
+
+// Returned by an encoder which cannot represent an entity, such as the CSV
+// encoder for an entity which is not a slice. Negotiation continues with the
+// next acceptable media type.
+var ErrUnsupportedEntity = errors.New("Entity cannot be represented in this media type")
+
+// An Encoder marshals response entities to a particular media type
+type Encoder interface {
+	Encode(body interface{}) ([]byte, error)
+}
+
+// A function which implements Encoder
+type EncoderFunc func(body interface{}) ([]byte, error)
+
+func (f EncoderFunc) Encode(body interface{}) ([]byte, error) {
+	return f(body)
+}
+
+// A media type and the encoder which produces it
+type Encoding struct {
+	Mimetype string
+	Encoder  Encoder
+}
+
+type encoderRegistry struct {
+	sync.RWMutex
+	encodings []Encoding
+}
+
+// The registered encodings, in order of preference
+var encoders = &encoderRegistry{
+	encodings: []Encoding{
+		{MimetypeJSON, EncoderFunc(json.Marshal)},
+		{MimetypeXML, EncoderFunc(xmlEncode)},
+		{MimetypeYAML, EncoderFunc(yamlEncode)},
+		{MimetypeMsgpack, EncoderFunc(msgpackEncode)},
+		{MimetypeCSV, EncoderFunc(csvEncode)},
+	},
+}
+
+// Register an encoder for a media type, which is offered by every subsequent
+// call to Negotiate. If an encoder is already registered for the media type
+// it is replaced and retains its preference; otherwise the encoder is least
+// preferred.
+//
+// Use WithEncoder to offer an encoder for a single call instead.
+func RegisterEncoder(mimetype string, enc Encoder) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	mimetype = strings.ToLower(mimetype)
+	for i, e := range encoders.encodings {
+		if e.Mimetype == mimetype {
+			encoders.encodings[i].Encoder = enc
+			return
+		}
+	}
+	encoders.encodings = append(encoders.encodings, Encoding{mimetype, enc})
+}
+
+// The encodings offered with a configuration, in order of preference; those
+// in the configuration are preferred to those which are registered
+func (c Config) offers() []Encoding {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	res := make([]Encoding, 0, len(c.Encoders)+len(encoders.encodings))
+	seen := make(map[string]struct{})
+	for _, set := range [][]Encoding{c.Encoders, encoders.encodings} {
+		for _, e := range set {
+			if _, ok := seen[e.Mimetype]; !ok {
+				seen[e.Mimetype] = struct{}{}
+				res = append(res, e)
+			}
+		}
+	}
+	return res
+}
+
+// Encode XML; a type which XML cannot represent, like a map, is unsupported
+// rather than a failure. So is a slice, which would produce a document with
+// more than one root element.
+func xmlEncode(body interface{}) ([]byte, error) {
+	v := reflect.ValueOf(body)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		return nil, fmt.Errorf("%w: XML documents have a single root, got %T", ErrUnsupportedEntity, body)
+	}
+	data, err := xml.Marshal(body)
+	var uterr *xml.UnsupportedTypeError
+	if errors.As(err, &uterr) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedEntity, err)
+	}
+	return data, err
+}
+
+// Encode YAML; the encoder panics rather than failing for a type it cannot
+// represent, like a function, so this is reported as an error instead
+func yamlEncode(body interface{}) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Could not encode YAML: %v", r)
+		}
+	}()
+	return yaml.Marshal(body)
+}
+
+// Encode MessagePack; fields are named by their msgpack struct tags or,
+// failing that, their json struct tags, so that they match the JSON encoding
+func msgpackEncode(body interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := msgpack.NewEncoder(buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode CSV. The entity must be a slice whose elements are either slices of
+// strings, which are written as records verbatim, or structs, which are
+// written with a header row naming their columns. Columns are named by csv
+// struct tags or, failing that, json struct tags; a field tagged "-" is
+// omitted.
+func csvEncode(body interface{}) ([]byte, error) {
+	v := reflect.ValueOf(body)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%w: expected a slice, got %T", ErrUnsupportedEntity, body)
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := csvRecords(w, v); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func csvRecords(w *csv.Writer, v reflect.Value) error {
+	t := v.Type().Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String {
+		for i := 0; i < v.Len(); i++ {
+			e := v.Index(i)
+			for e.Kind() == reflect.Pointer && !e.IsNil() {
+				e = e.Elem()
+			}
+			var rec []string // nil elements are empty records, like nil records
+			if e.Kind() != reflect.Pointer {
+				rec = make([]string, e.Len())
+				for j := range rec {
+					rec[j] = e.Index(j).String()
+				}
+			}
+			if err := w.Write(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: expected a slice of structs or records, got elements of %v", ErrUnsupportedEntity, t)
+	}
+
+	var names []string
+	var index []int
+	for i := 0; i < t.NumField(); i++ {
+		if n, ok := csvColumn(t.Field(i)); ok {
+			names = append(names, n)
+			index = append(index, i)
+		}
+	}
+	if err := w.Write(names); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		for e.Kind() == reflect.Pointer && !e.IsNil() {
+			e = e.Elem()
+		}
+		if e.Kind() == reflect.Pointer {
+			continue // nil elements produce no record
+		}
+		rec := make([]string, len(index))
+		for j, x := range index {
+			rec[j] = csvValue(e.Field(x))
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Determine the column name for a struct field, if it is included
+func csvColumn(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	for _, k := range []string{"csv", "json"} {
+		if tag, ok := f.Tag.Lookup(k); ok {
+			n, _, _ := strings.Cut(tag, ",")
+			if n == "-" {
+				return "", false
+			} else if n != "" {
+				return n, true
+			}
+		}
+	}
+	return f.Name, true
+}
+
+// Format a field value for a CSV record
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
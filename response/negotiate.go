@@ -0,0 +1,189 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+	"github.com/bww/go-router/v2"
+	"github.com/bww/go-router/v2/entity"
+)
+
+// Error codes produced by Negotiate. These are stable and may be relied upon
+// by clients.
+const (
+	CodeNotAcceptable resterrs.Code = "not_acceptable"
+)
+
+// Produce a successful response, optionally including a payload, which is
+// encoded in the media type the request's Accept header prefers among those
+// offered. The status code used is 200 unless otherwise specified via an
+// option.
+//
+// JSON, XML, YAML, MessagePack and CSV are offered by default, in that order
+// of preference, which breaks ties between equally acceptable types; CSV can
+// only represent slices, and XML cannot represent maps or slices. Other types
+// are offered by registering an Encoder for them, either with RegisterEncoder
+// or, for a single call, with the WithEncoder option. A request without an
+// Accept header receives the most preferred type.
+//
+// If none of the offered types is acceptable, a 406 error which lists them is
+// returned. If the payload cannot be encoded, a 500 error is returned.
+func Negotiate(req *router.Request, body interface{}, opts ...Option) (*router.Response, error) {
+	conf := Config{}.WithOptions(opts)
+	rsp := router.NewResponse(statusOr(conf.Status, http.StatusOK))
+	// start with the provided header, if any
+	if len(conf.Header) > 0 {
+		rsp.Header = conf.Header
+	}
+	// the response depends on the request's accepted types
+	if !headerHasToken(rsp.Header, "Vary", "Accept") {
+		rsp.Header.Add("Vary", "Accept")
+	}
+	if body == nil {
+		return rsp, nil
+	}
+
+	offers := conf.offers()
+	ranges := parseAccept(req.Header.Values("Accept"))
+	for _, e := range rankOffers(ranges, offers) {
+		data, err := encode(e.Encoder, body)
+		if errors.Is(err, ErrUnsupportedEntity) {
+			continue
+		} else if err != nil {
+			return nil, resterrs.New(http.StatusInternalServerError, "Could not encode response entity as "+e.Mimetype, err)
+		}
+		ent, err := entity.NewBytes(e.Mimetype, data)
+		if err != nil {
+			return nil, resterrs.New(http.StatusInternalServerError, "Could not create response entity", err)
+		}
+		// setting the body will update the content type
+		_, err = rsp.SetEntity(ent)
+		if err != nil {
+			return nil, resterrs.New(http.StatusInternalServerError, "Could not set response entity", err)
+		}
+		return rsp, nil
+	}
+
+	supported := make([]string, len(offers))
+	for i, e := range offers {
+		supported[i] = e.Mimetype
+	}
+	return nil, resterrs.Errorf(http.StatusNotAcceptable, "No acceptable representation: %s", strings.Join(req.Header.Values("Accept"), ", ")).
+		SetCode(CodeNotAcceptable).
+		AddDetail(map[string]interface{}{"supported": supported})
+}
+
+// Encode an entity, reporting a panic in the encoder as an error so that a
+// misbehaving encoder produces a 500 response like any other failure
+func encode(enc Encoder, body interface{}) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return enc.Encode(body)
+}
+
+// A media range from an Accept header
+type mediaRange struct {
+	typ, sub string
+	q        float64
+}
+
+// The specificity of a media range; a more specific range takes precedence
+// over a less specific one which also matches
+func (r mediaRange) specificity() int {
+	if r.typ == "*" {
+		return 0
+	} else if r.sub == "*" {
+		return 1
+	} else {
+		return 2
+	}
+}
+
+func (r mediaRange) matches(typ, sub string) bool {
+	return (r.typ == "*" || r.typ == typ) && (r.sub == "*" || r.sub == sub)
+}
+
+// Parse Accept header values. Ranges which cannot be parsed are ignored and
+// parameters other than the quality are disregarded.
+func parseAccept(vals []string) []mediaRange {
+	var res []mediaRange
+	for _, v := range vals {
+		for _, e := range strings.Split(v, ",") {
+			if strings.TrimSpace(e) == "" {
+				continue
+			}
+			m, params, err := mime.ParseMediaType(e)
+			if err != nil {
+				continue
+			}
+			typ, sub, ok := strings.Cut(m, "/")
+			if !ok || (typ == "*" && sub != "*") {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+					q = f
+				}
+			}
+			res = append(res, mediaRange{typ, sub, q})
+		}
+	}
+	return res
+}
+
+// Order the acceptable offers by quality, then by preference. The quality of
+// an offer is that of the most specific range which matches it; offers which
+// are not acceptable are omitted. If there are no ranges, every offer is
+// acceptable.
+func rankOffers(ranges []mediaRange, offers []Encoding) []Encoding {
+	if len(ranges) == 0 {
+		return offers
+	}
+	type ranked struct {
+		Encoding
+		q float64
+	}
+	var res []ranked
+	for _, e := range offers {
+		typ, sub, _ := strings.Cut(e.Mimetype, "/")
+		q, spec := 0.0, -1
+		for _, r := range ranges {
+			if r.matches(typ, sub) && r.specificity() > spec {
+				q, spec = r.q, r.specificity()
+			}
+		}
+		if q > 0 {
+			res = append(res, ranked{e, q})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].q > res[j].q
+	})
+	encs := make([]Encoding, len(res))
+	for i, e := range res {
+		encs[i] = e.Encoding
+	}
+	return encs
+}
+
+// Determine if a header carries a comma-separated list containing a token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, e := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(e), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
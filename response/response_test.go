@@ -1,12 +1,18 @@
 package response
 
 import (
+	"bytes"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	htmltempl "html/template"
+	"io"
+	"net/http"
 	"testing"
 	texttempl "text/template"
 
+	resterrs "github.com/bww/go-rest/v2/errors"
+	"github.com/bww/go-router/v2"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/stretchr/testify/assert"
 )
@@ -121,3 +127,92 @@ func TestResponseTemplateCache(t *testing.T) {
 	assert.Equal(t, false, hit)
 	assert.Equal(t, cache.Len(), 2)
 }
+
+type negotiateEntity struct {
+	Name    string `json:"name" xml:"name" yaml:"name"`
+	Count   int    `json:"count" xml:"count" yaml:"count"`
+	Private string `json:"-" xml:"-" yaml:"-"`
+}
+
+func TestNegotiate(t *testing.T) {
+	one := negotiateEntity{Name: "Hello", Count: 3}
+	many := []negotiateEntity{{Name: "Hello", Count: 3}, {Name: "Hi, there", Count: 4}}
+	mpack, err := msgpackEncode(one)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		Accept string
+		Body   interface{}
+		Opts   []Option
+		Type   string
+		Expect string
+		Status int
+	}{
+		{"", one, nil, "application/json", `{"name":"Hello","count":3}`, 0},
+		{"*/*", one, nil, "application/json", `{"name":"Hello","count":3}`, 0},
+		{"application/xml", one, nil, "application/xml", `<negotiateEntity><name>Hello</name><count>3</count></negotiateEntity>`, 0},
+		{"application/json;q=0.5, application/yaml", one, nil, "application/yaml", "name: Hello\ncount: 3\n", 0},
+		{"application/*;q=0.9, application/json;q=0.1", one, nil, "application/xml", `<negotiateEntity><name>Hello</name><count>3</count></negotiateEntity>`, 0},
+		{"application/msgpack", one, nil, "application/msgpack", string(mpack), 0},
+		{"text/csv", many, nil, "text/csv", "name,count\nHello,3\n\"Hi, there\",4\n", 0},
+		{"text/csv, application/json;q=0.5", one, nil, "application/json", `{"name":"Hello","count":3}`, 0},
+		{"text/*", [][]string{{"a", "b"}, {"c", "d"}}, nil, "text/csv", "a,b\nc,d\n", 0},
+		{"text/csv", []*[]string{{"a", "b"}, nil}, nil, "text/csv", "a,b\n\n", 0},
+		{"application/xml, application/json;q=0.1", map[string]int{"a": 1}, nil, "application/json", `{"a":1}`, 0},
+		{"application/xml, application/json;q=0.1", many, nil, "application/json", `[{"name":"Hello","count":3},{"name":"Hi, there","count":4}]`, 0},
+		{"application/xml", &many, nil, "", "", http.StatusNotAcceptable},
+		{"text/plain", "Hello", []Option{WithEncoder("text/plain", EncoderFunc(func(v interface{}) ([]byte, error) { return []byte(v.(string)), nil }))}, "text/plain", "Hello", 0},
+		{"text/html", one, nil, "", "", http.StatusNotAcceptable},
+		{"text/csv", one, nil, "", "", http.StatusNotAcceptable},
+		{"application/json;q=0", one, nil, "", "", http.StatusNotAcceptable},
+		{"application/json", make(chan int), nil, "", "", http.StatusInternalServerError},
+		{"application/yaml", map[string]interface{}{"f": func() {}}, nil, "", "", http.StatusInternalServerError},
+		{"text/plain", "Hello", []Option{WithEncoder("text/plain", EncoderFunc(func(v interface{}) ([]byte, error) { panic("Oh no") }))}, "", "", http.StatusInternalServerError},
+	}
+	for _, e := range tests {
+		req, err := http.NewRequest("GET", "/", nil)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		if e.Accept != "" {
+			req.Header.Set("Accept", e.Accept)
+		}
+		rsp, err := Negotiate((*router.Request)(req), e.Body, e.Opts...)
+		if e.Status != 0 {
+			if resterr, ok := err.(*resterrs.Error); assert.True(t, ok, "%s: %v", e.Accept, err) {
+				assert.Equal(t, e.Status, resterr.Status, e.Accept)
+				if e.Status == http.StatusNotAcceptable {
+					assert.Equal(t, CodeNotAcceptable, resterr.Code, e.Accept)
+					assert.Contains(t, resterr.Detail["supported"], "application/json", e.Accept)
+				}
+			}
+			continue
+		}
+		if !assert.NoError(t, err, e.Accept) {
+			continue
+		}
+		assert.Equal(t, http.StatusOK, rsp.Status, e.Accept)
+		assert.Equal(t, e.Type, rsp.Header.Get("Content-Type"), e.Accept)
+		assert.Equal(t, "Accept", rsp.Header.Get("Vary"), e.Accept)
+		data, err := rsp.ReadEntity()
+		if assert.NoError(t, err, e.Accept) {
+			assert.Equal(t, e.Expect, string(data), e.Accept)
+		}
+		if e.Type == "application/xml" {
+			// the document must have a single root element
+			dec := xml.NewDecoder(bytes.NewReader(data))
+			assert.NoError(t, dec.Decode(&negotiateEntity{}), e.Accept)
+			_, err := dec.Token()
+			assert.Equal(t, io.EOF, err, e.Accept)
+		}
+	}
+
+	// a nil body produces no entity, but the response still varies
+	req, _ := http.NewRequest("GET", "/", nil)
+	rsp, err := Negotiate((*router.Request)(req), nil, WithStatus(http.StatusAccepted), WithHeader("Vary", "Origin, accept"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusAccepted, rsp.Status)
+		assert.Nil(t, rsp.Entity)
+		assert.Equal(t, []string{"Origin, accept"}, rsp.Header.Values("Vary"))
+	}
+}
@@ -1,7 +1,7 @@
 package rest
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,29 +9,40 @@ import (
 	"maps"
 	"net/http"
 	"net/url"
-	"os"
 	"runtime/debug"
 	"time"
 
 	resterrs "github.com/bww/go-rest/v2/errors"
+	"github.com/bww/go-rest/v2/tracing"
 
-	"github.com/bww/go-metrics/v1"
 	"github.com/bww/go-router/v2"
 	errutil "github.com/bww/go-util/v1/errors"
 	"github.com/bww/go-util/v1/ext"
-	"github.com/bww/go-util/v1/text"
 )
 
 type Service struct {
 	router.Router
 
 	dflt    router.Handler
+	panics  PanicHandler
+	errors  ErrorHandler
+	encoder resterrs.Encoder
+	mapping *resterrs.Registry
+	reqid   string
+	idgen   func() string
 	log     *slog.Logger
+	access  *accessLogger
+	zip     *compressor
+	debug   *debugger
 	verbose bool
-	debug   bool
 
-	metrics        *metrics.Metrics
-	requestSampler metrics.SamplerVec
+	metrics *serviceMetrics
+	tracer  *tracing.Tracer
+	timeout time.Duration
+	tstatus int
+	health  health
+	server  ServerConfig
+	hooks   shutdownHooks
 }
 
 func New(opts ...Option) (*Service, error) {
@@ -43,65 +54,132 @@ func New(opts ...Option) (*Service, error) {
 	s := &Service{
 		Router:  router.New(),
 		dflt:    conf.Default,
+		panics:  conf.PanicHandler,
+		errors:  conf.ErrorHandler,
+		encoder: conf.ErrorEncoder,
+		mapping: ext.Coalesce(conf.ErrorMappings, resterrs.DefaultRegistry),
+		reqid:   ext.Coalesce(conf.RequestIDHeader, defaultRequestIDHeader),
+		idgen:   conf.RequestIDGenerator,
 		log:     ext.Coalesce(conf.Logger, slog.Default()),
+		tracer:  conf.Tracer,
+		timeout: conf.Timeout,
+		tstatus: ext.Coalesce(conf.TimeoutStatus, defaultTimeoutStatus),
+		server:  conf.Server,
 		verbose: conf.Verbose,
-		debug:   conf.Debug,
 	}
 
+	s.hooks.list = conf.ShutdownHooks
+	s.health.timeout = ext.Coalesce(conf.HealthTimeout, defaultHealthTimeout)
+	for k, v := range conf.HealthChecks {
+		s.AddHealthCheck(k, v)
+	}
+	if conf.Debug || len(conf.DebugConfig.Predicates) > 0 {
+		s.debug = newDebugger(conf.DebugConfig)
+	}
+	if conf.AccessLog != nil {
+		s.access = newAccessLogger(*conf.AccessLog)
+	}
+	if conf.Compression != nil {
+		s.zip = newCompressor(*conf.Compression)
+	}
 	if conf.Metrics != nil {
-		s.requestSampler = conf.Metrics.RegisterSamplerVec("rest_request", "Request sampler", []string{"status"})
+		s.metrics = newServiceMetrics(conf.Metrics)
 	}
 	return s, nil
 }
 
-func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+func (s *Service) ServeHTTP(hw http.ResponseWriter, req *http.Request) {
 	var rsp *router.Response
+	var pattern, rlabel string
 	var err error
 
+	w := newResponseWriter(hw)
+
+	reqid := s.requestID(req)
+	w.Header().Set(s.reqid, reqid)
+
+	// start with a logger provided upstream, if any, so that its attributes
+	// are carried through to our logging
 	method, rcname := resource((*router.Request)(req))
-	log := s.log.With("method", method, "resource", rcname)
+	log := ext.Coalesce(loggerFromContext(req.Context()), s.log).With("method", method, "resource", rcname, "request_id", reqid)
 	start := time.Now()
+
+	var span *tracing.Span
+	if s.tracer != nil {
+		var cxt context.Context
+		cxt, span = s.tracer.Start(req.Context(), method, tracing.SpanKindServer, tracing.Extract(req.Header))
+		req = req.WithContext(cxt)
+		span.SetAttr("http.request.method", method).SetAttr("url.path", req.URL.Path).SetAttr("request_id", reqid)
+		log = log.With("trace_id", span.Context().TraceID.String(), "span_id", span.Context().SpanID.String())
+		defer func() {
+			status := ext.Coalesce(w.Status(), http.StatusOK)
+			span.SetAttr("http.response.status_code", status)
+			if status >= 500 && span.Status() != tracing.StatusError {
+				span.SetStatus(tracing.StatusError, http.StatusText(status))
+			}
+			span.End()
+		}()
+	}
+
+	req = req.WithContext(NewLoggerContext(NewRequestIDContext(req.Context(), reqid), log))
+
+	var body *countingReader
+	if s.metrics != nil {
+		s.metrics.inflight.Inc()
+		defer s.metrics.inflight.Dec()
+		if req.Body != nil {
+			body = &countingReader{ReadCloser: req.Body}
+			req.Body = body
+		}
+	}
+
 	defer func() {
 		if err := recover(); err != nil {
-			log.With("because", err).Error("PANIC")
-			fmt.Println(string(debug.Stack()))
+			// a panic recovered here occurred outside of the handler; by this
+			// point it may no longer be possible to produce a response
+			log.With("because", err, "stack", string(debug.Stack())).Error("PANIC")
+			if span != nil {
+				span.SetError(&PanicError{Value: err})
+			}
+			if s.metrics != nil {
+				s.metrics.Panic(method, rlabel)
+			}
 			return
 		}
-		if rsp != nil {
-			if s.requestSampler != nil {
-				s.requestSampler.With(metrics.Tags{"status": fmt.Sprint(rsp.Status)}).Observe(float64(time.Since(start)))
+		if s.metrics != nil {
+			var reqsize int64
+			if req.ContentLength > 0 {
+				reqsize = req.ContentLength
+			} else if body != nil {
+				reqsize = body.n
 			}
+			s.metrics.Observe(requestSample{
+				Method:   method,
+				Route:    rlabel,
+				Status:   ext.Coalesce(w.Status(), http.StatusOK),
+				Duration: float64(time.Since(start)),
+				ReqSize:  reqsize,
+				RspSize:  w.Bytes(),
+			})
 		}
-	}()
-
-	var dump *bytes.Buffer
-	if s.debug {
-		method, rcname := resource((*router.Request)(req))
-		dump = &bytes.Buffer{}
-		dump.WriteString(text.Indent(fmt.Sprintf("%s %s", method, rcname), "  > "))
-		dump.WriteString("\n")
-
-		data := &bytes.Buffer{}
-		req.Header.Write(data)
-		dump.WriteString(text.Indent(data.String(), "  > "))
-		dump.WriteString("\n")
-
-		mtype := req.Header.Get("Content-Type")
-		if !isMimetypeBinary(mtype) {
-			data := &bytes.Buffer{}
-			_, err := io.Copy(data, req.Body)
-			if err != nil {
-				errlog(log, err).Error("Could not read request entity")
-			} else {
-				dump.WriteString(text.Indent(data.String(), "  > "))
-				dump.WriteString("\n")
-			}
-			req.Body = io.NopCloser(data)
-		} else if mtype != "" {
-			dump.WriteString(text.Indent("[binary data]", "  > "))
-			dump.WriteString("\n")
+		if s.access != nil {
+			s.access.Log(log, accessEntry{
+				Time:      start,
+				Method:    method,
+				Resource:  rcname,
+				Proto:     req.Proto,
+				Route:     pattern,
+				Status:    ext.Coalesce(w.Status(), http.StatusOK),
+				Bytes:     w.Bytes(),
+				Duration:  time.Since(start),
+				Remote:    (*router.Request)(req).OriginAddr(),
+				User:      basicAuthUser(req),
+				Referer:   req.Referer(),
+				UserAgent: req.UserAgent(),
+				RequestID: reqid,
+			})
 		}
-	}
+	}()
 
 	var (
 		rrq *router.Request
@@ -112,76 +190,84 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		errlog(log, err).Error("Error finding route")
 		rrq = (*router.Request)(req)
+		rlabel = routeLabelError
 		hdl = first(s.dflt, s.handle500)
 	} else if route == nil {
 		log.Error("Route not found")
 		rrq = (*router.Request)(req)
+		rlabel = routeLabelNotFound
 		hdl = first(s.dflt, s.handle404)
 	} else {
 		rrq = (*router.Request)((*http.Request)(req).WithContext(router.NewMatchContext(req.Context(), match)))
 		cxt = route.Context(match)
 		hdl = s.handler(route)
+		pattern = match.Path
+		rlabel = match.Path
+		if span != nil {
+			span.SetName(method+" "+pattern).SetAttr("http.route", pattern)
+		}
+	}
+
+	var dump *DebugExchange
+	if s.debug != nil && s.debug.capture(req, pattern) {
+		dump, err = s.debug.captureRequest(req, reqid, start)
+		if err != nil {
+			errlog(log, err).Error("Could not read request entity")
+		}
+		dump.Route = pattern
+		rrq.Body = req.Body // the entity is replaced when it is captured
 	}
 
-	rsp, err = hdl(rrq, cxt)
+	if d := routeTimeout(cxt, s.timeout); d > 0 {
+		rsp, err = s.invokeWithTimeout(log, rlabel, hdl, rrq, cxt, d)
+	} else {
+		rsp, err = s.invoke(log, rlabel, hdl, rrq, cxt)
+	}
 	if err != nil {
 		errlog(log, err).Error("Handler failed")
-		return
+		rsp = s.handleError(rrq, err)
+	}
+	if dump != nil {
+		err := s.debug.captureResponse(dump, rsp)
+		if err != nil {
+			errlog(log, err).Error("Could not read response entity")
+		}
+		err = s.debug.dump(dump)
+		if err != nil {
+			errlog(log, err).Error("Could not dump request")
+		}
 	}
 	if rsp == nil {
 		w.WriteHeader(http.StatusOK) // nil response is an empty 200
 		return
 	}
 
-	maps.Copy(w.Header(), rsp.Header)
-	w.WriteHeader(rsp.Status)
-
-	if s.debug {
-		fmt.Fprintf(dump, "  *\n  < %d / %s\n", rsp.Status, http.StatusText(rsp.Status))
-		data := &bytes.Buffer{}
-		rsp.Header.Write(data)
-		dump.WriteString(text.Indent(data.String(), "  < "))
-		dump.WriteString("\n")
-
+	if isStreaming(rsp) {
+		// streaming responses are expected to outlive the server's write
+		// timeout; this fails harmlessly if the writer does not support it
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
 	}
 
-	if dump != nil && isStreaming(rsp) {
-		// NOTE: this is a very specific workaround for SSE responses, which
-		// are not generally compatible with the buffered approach we take to
-		// debugging responses.
-		dump.WriteString("  ~ <STREAMING DATA OMITTED>\n")
-		_, err := io.Copy(os.Stdout, dump)
-		if err != nil {
-			errlog(log, err).Error("Could not dump request")
+	maps.Copy(w.Header(), rsp.Header)
+	w.Header().Set(s.reqid, reqid)
+
+	var out io.Writer = w
+	var enc *compressWriter
+	if s.zip != nil {
+		enc = s.zip.encoder(req, rsp, w.Header(), w)
+		if enc != nil {
+			out = enc
 		}
-		dump = nil // don't attempt to dump the entity
 	}
 
+	w.WriteHeader(rsp.Status)
+
 	if entity := rsp.Entity; entity != nil {
 		defer entity.Close()
-		if dump != nil {
-			mtype := rsp.Header.Get("Content-Type")
-			if !isMimetypeBinary(mtype) {
-				data := &bytes.Buffer{}
-				_, err := io.Copy(data, entity)
-				if err != nil {
-					errlog(log, err).Error("Could not read response entity")
-				} else {
-					dump.WriteString(text.Indent(data.String(), "  < "))
-					dump.WriteString("\n")
-				}
-				entity = io.NopCloser(data)
-			} else if mtype != "" {
-				dump.WriteString(text.Indent("[binary data]", "  < "))
-				dump.WriteString("\n")
-			}
-
-			_, err := io.Copy(os.Stdout, dump)
-			if err != nil {
-				errlog(log, err).Error("Could not dump request")
-			}
+		_, err := io.Copy(out, entity)
+		if enc != nil {
+			err = errors.Join(err, enc.Close())
 		}
-		_, err := io.Copy(w, entity)
 		if err != nil {
 			errlog(log, err).Error("Could not write response entity")
 		}
@@ -190,8 +276,8 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func (s *Service) handler(route *router.Route) router.Handler {
 	return router.Handler(func(req *router.Request, cxt router.Context) (*router.Response, error) {
-		method, rcname := resource((*router.Request)(req))
-		log := s.log.With("method", method, "resource", rcname)
+		log := Logger(req.Context()).With("route", cxt.Path)
+		req = (*router.Request)((*http.Request)(req).WithContext(NewLoggerContext(req.Context(), log)))
 		if s.verbose {
 			log.Info(req.OriginAddr())
 		}
@@ -202,26 +288,114 @@ func (s *Service) handler(route *router.Route) router.Handler {
 		}
 
 		errlog(log, err).Error(err.Error())
+		if s.metrics != nil {
+			s.metrics.Error(req.Method, cxt.Path)
+		}
 
+		var resterr *resterrs.Error
 		var rsperr resterrs.Responder
-		if errors.As(err, &rsperr) {
-			return rsperr.Response(), nil
-		} else {
-			return rsp, err
+		var ersp *router.Response
+		if errors.As(err, &resterr) {
+			ersp = s.errorResponse(req, resterr)
+		} else if errors.As(err, &rsperr) {
+			ersp = rsperr.Response()
+		} else if mapped, ok := s.mapping.Map(err); ok {
+			ersp = s.errorResponse(req, mapped)
 		}
+
+		// client errors are not failures of the server; only server errors
+		// and errors which could not be converted mark the span as failed
+		if span := tracing.FromContext(req.Context()); span != nil && (ersp == nil || ersp.Status >= 500) {
+			span.SetError(err)
+		}
+		if ersp != nil {
+			return ersp, nil
+		}
+		return rsp, err
 	})
 }
 
+// Invoke a handler, recovering from any panic that occurs within it. A
+// panic is converted into a response by the panic handler and is attributed
+// to the provided route label.
+func (s *Service) invoke(log *slog.Logger, rlabel string, hdl router.Handler, req *router.Request, cxt router.Context) (rsp *router.Response, err error) {
+	defer func() {
+		if cause := recover(); cause != nil {
+			rsp, err = s.handlePanic(log, rlabel, req, &PanicError{Value: cause, Stack: debug.Stack()}), nil
+		}
+	}()
+	return hdl(req, cxt)
+}
+
+// Handle a panic recovered from a handler; the panic is logged along with
+// its stack and a response is produced for the client
+func (s *Service) handlePanic(log *slog.Logger, rlabel string, req *router.Request, cause *PanicError) *router.Response {
+	err := resterrs.New(http.StatusInternalServerError, "Internal server error", cause)
+	errlog(log, err).With("stack", string(cause.Stack)).Error("PANIC")
+	if s.metrics != nil {
+		s.metrics.Panic(req.Method, rlabel)
+	}
+	if span := tracing.FromContext(req.Context()); span != nil {
+		span.SetError(cause)
+	}
+	if s.panics != nil {
+		if rsp := s.panics(req, err); rsp != nil {
+			return rsp
+		}
+	}
+	return s.errorResponse(req, err)
+}
+
+// Handle an error returned by a handler that could not be converted to a
+// response on its own; the error handler, if any, renders the response
+func (s *Service) handleError(req *router.Request, err error) *router.Response {
+	if s.errors != nil {
+		if rsp := s.errors(req, err); rsp != nil {
+			return rsp
+		}
+	}
+	return s.errorResponse(req, err500)
+}
+
+// Produce a response for an error using the service's error encoder, unless
+// the error specifies its own. The error is annotated with the request and
+// trace IDs.
+func (s *Service) errorResponse(req *router.Request, err *resterrs.Error) *router.Response {
+	reqid := RequestID(req.Context())
+	var traceid string
+	if span := tracing.FromContext(req.Context()); span != nil {
+		traceid = span.Context().TraceID.String()
+	}
+	if (reqid != "" && err.RequestID == "") || (traceid != "" && err.TraceID == "") {
+		err = err.Copy() // errors may be shared; don't modify the original
+		err.RequestID = ext.Coalesce(err.RequestID, reqid)
+		err.TraceID = ext.Coalesce(err.TraceID, traceid)
+	}
+	return err.ResponseWithEncoder(s.encoder)
+}
+
+// Determine the ID for a request. An ID provided by the client is used if
+// it is valid; otherwise, a new ID is generated.
+func (s *Service) requestID(req *http.Request) string {
+	if id := req.Header.Get(s.reqid); validRequestID(id) {
+		return id
+	}
+	if s.idgen != nil {
+		return s.idgen()
+	}
+	return newRequestID()
+}
+
 var (
 	err404 = resterrs.Errorf(http.StatusNotFound, "Not found")
 	err500 = resterrs.Errorf(http.StatusInternalServerError, "Internal server error")
 )
 
 func (s *Service) handle404(req *router.Request, cxt router.Context) (*router.Response, error) {
-	return err404.Response(), nil
+	return s.errorResponse(req, err404), nil
 }
 func (s *Service) handle500(req *router.Request, cxt router.Context) (*router.Response, error) {
-	return err500.Response(), nil
+	return s.errorResponse(req, err500), nil
 }
 
 // Streaming content types
@@ -268,6 +442,12 @@ func resource(req *router.Request) (string, string) {
 	return req.Method, r
 }
 
+// Obtain the user provided via basic auth, if any
+func basicAuthUser(req *http.Request) string {
+	user, _, _ := req.BasicAuth()
+	return user
+}
+
 // Produce a logger for an error
 func errlog(log *slog.Logger, err error) *slog.Logger {
 	if referr, ok := err.(errutil.Referenced); ok {
@@ -1,13 +1,35 @@
 package rest
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	resterrs "github.com/bww/go-rest/v2/errors"
+	"github.com/bww/go-rest/v2/tracing"
+
+	"github.com/bww/go-metrics/v1"
 	"github.com/bww/go-router/v2"
 	"github.com/bww/go-router/v2/entity"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -141,11 +163,534 @@ func testService(t *testing.T, opts ...Option) {
 		s.ServeHTTP(rec, e.Req)
 		rsp := rec.Result()
 		assert.Equal(t, e.Rsp.Status, rsp.StatusCode)
+		assert.NotEmpty(t, rsp.Header.Get("X-Request-Id"))
+		rsp.Header.Del("X-Request-Id") // request IDs are generated
 		assert.Equal(t, e.Rsp.Header, rsp.Header)
 		assert.Equal(t, readAll(e.Rsp.Entity), readAll(rsp.Body))
 	}
 }
 
+func TestServicePanic(t *testing.T) {
+	funcP := func(*router.Request, router.Context) (*router.Response, error) {
+		panic("Oh no")
+	}
+
+	s, _ := New()
+	s.Add("/p", funcP).Methods("GET")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/p", nil))
+	rsp := rec.Result()
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	assert.Contains(t, readAll(rsp.Body), `"ref":"err-`)
+
+	var cause *PanicError
+	s, _ = New(WithPanicHandler(func(req *router.Request, err *resterrs.Error) *router.Response {
+		if assert.ErrorAs(t, err, &cause) {
+			assert.Equal(t, "Oh no", cause.Value)
+			assert.NotEmpty(t, cause.Stack)
+		}
+		return mustNewResponse(http.StatusServiceUnavailable, "text/plain", "Sorry")
+	}))
+	s.Add("/p", funcP).Methods("GET")
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/p", nil))
+	rsp = rec.Result()
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.Equal(t, "Sorry", readAll(rsp.Body))
+}
+
+func TestServiceError(t *testing.T) {
+	funcE := func(*router.Request, router.Context) (*router.Response, error) {
+		return nil, errors.New("Plain error")
+	}
+
+	s, _ := New()
+	s.Add("/e", funcE).Methods("GET")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/e", nil))
+	rsp := rec.Result()
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	assert.Contains(t, readAll(rsp.Body), `"message":"Internal server error"`)
+
+	funcM := func(*router.Request, router.Context) (*router.Response, error) {
+		return nil, fmt.Errorf("Could not find it: %w", sql.ErrNoRows)
+	}
+	s.Add("/m", funcM).Methods("GET")
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/m", nil))
+	rsp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	assert.Contains(t, readAll(rsp.Body), `"code":"not_found"`)
+
+	s, _ = New(WithErrorHandler(func(req *router.Request, err error) *router.Response {
+		return mustNewResponse(http.StatusBadGateway, "text/plain", err.Error())
+	}))
+	s.Add("/e", funcE).Methods("GET")
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/e", nil))
+	rsp = rec.Result()
+	assert.Equal(t, http.StatusBadGateway, rsp.StatusCode)
+	assert.Equal(t, "Plain error", readAll(rsp.Body))
+}
+
+func TestServiceRequestID(t *testing.T) {
+	var seen string
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		seen = RequestID(req.Context())
+		return nil, resterrs.Errorf(http.StatusBadRequest, "Bad request")
+	}
+
+	s, _ := New(WithRequestIDGenerator(func() string { return "generated" }))
+	s.Add("/a", funcA).Methods("GET")
+
+	tests := []struct {
+		Header string
+		Expect string
+	}{
+		{"", "generated"},
+		{"abc-123", "abc-123"},
+		{"not valid!", "generated"},
+		{strings.Repeat("x", 129), "generated"},
+	}
+	for _, e := range tests {
+		req := mustReq("GET", "/a", nil)
+		if e.Header != "" {
+			req.Header.Set("X-Request-Id", e.Header)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		rsp := rec.Result()
+		assert.Equal(t, e.Expect, seen)
+		assert.Equal(t, e.Expect, rsp.Header.Get("X-Request-Id"))
+		assert.Contains(t, readAll(rsp.Body), `"request_id":"`+e.Expect+`"`)
+	}
+}
+
+func TestServiceLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		Logger(req.Context()).Info("Handling")
+		return nil, nil
+	}
+
+	s, _ := New(WithLogger(slog.New(slog.NewTextHandler(buf, nil))))
+	s.Add("/a/{id}", funcA).Methods("GET")
+
+	req := mustReq("GET", "/a/123", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buf.String(), `msg=Handling method=GET resource=/a/123 request_id=abc route=/a/{id}`)
+
+	// a logger provided upstream is used as the base logger
+	buf.Reset()
+	req = mustReq("GET", "/a/123", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	req = req.WithContext(NewLoggerContext(req.Context(), slog.New(slog.NewTextHandler(buf, nil)).With("upstream", 1)))
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buf.String(), `msg=Handling upstream=1 method=GET resource=/a/123 request_id=abc route=/a/{id}`)
+}
+
+func TestServiceAccessLog(t *testing.T) {
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return router.NewResponse(http.StatusCreated).SetString("text/plain", "Hello")
+	}
+	funcH := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return nil, nil
+	}
+
+	tests := []struct {
+		Format AccessLogFormat
+		Path   string
+		Expect string
+	}{
+		{AccessLogLogfmt, "/a/1", `method=GET resource=/a/1 route=/a/{id} status=201 bytes=5 `},
+		{AccessLogLogfmt, "/a/1", ` user_agent=Test/1.0 request_id=abc`},
+		{AccessLogCombined, "/a/1", `"GET /a/1 HTTP/1.1" 201 5 "-" "Test/1.0"`},
+		{AccessLogLogfmt, "/health", ``},
+		{AccessLogLogfmt, "/missing", `route="" status=404 `},
+	}
+	for _, e := range tests {
+		buf := &bytes.Buffer{}
+		s, _ := New(WithAccessLog(AccessLogConfig{
+			Format:   e.Format,
+			Writer:   buf,
+			Suppress: []string{"/health"},
+		}))
+		s.Add("/a/{id}", funcA).Methods("GET")
+		s.Add("/health", funcH).Methods("GET")
+
+		req := mustReq("GET", e.Path, nil)
+		req.Header.Set("X-Request-Id", "abc")
+		req.Header.Set("User-Agent", "Test/1.0")
+		s.ServeHTTP(httptest.NewRecorder(), req)
+		if e.Expect == "" {
+			assert.Equal(t, "", buf.String())
+		} else {
+			assert.Contains(t, buf.String(), e.Expect)
+		}
+	}
+}
+
+func TestServiceDebug(t *testing.T) {
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		return router.NewResponse(http.StatusOK).SetBytes("application/json", data)
+	}
+
+	sink := NewRingSink(2)
+	s, _ := New(WithDebug(true), WithDebugSink(sink), WithDebugMaxBody(64))
+	s.Add("/a", funcA).Methods("POST")
+
+	tests := []struct {
+		Path     string
+		Entity   string
+		Request  string
+		Response string
+	}{
+		{
+			"/a?token=secret&page=1",
+			`{"name":"Bob","auth":{"password":"hunter2"}}`,
+			`{"auth":{"password":"[REDACTED]"},"name":"Bob"}`,
+			`{"auth":{"password":"[REDACTED]"},"name":"Bob"}`,
+		},
+		{
+			"/a",
+			`{"password":"hunter2","padding":"` + strings.Repeat("x", 64) + `"}`,
+			`{"password":"[REDACTED]","padding":"` + strings.Repeat("x", 31),
+			`{"password":"[REDACTED]","padding":"` + strings.Repeat("x", 31),
+		},
+	}
+	for _, e := range tests {
+		req := mustReq("POST", e.Path, mustEntity("application/json", []byte(e.Entity)))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		rsp := rec.Result()
+		assert.Equal(t, e.Entity, readAll(rsp.Body)) // the entity is unaffected
+
+		xs := sink.Exchanges()
+		x := xs[len(xs)-1]
+		assert.NotContains(t, x.Request.URL, "secret")
+		assert.Equal(t, "[REDACTED]", x.Request.Header.Get("Authorization"))
+		assert.Equal(t, e.Request, string(x.Request.Entity.Data))
+		assert.Equal(t, e.Response, string(x.Response.Entity.Data))
+		assert.Equal(t, len(e.Entity) > 64, x.Response.Entity.Truncated)
+	}
+}
+
+func TestServiceDebugPredicate(t *testing.T) {
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return router.NewResponse(http.StatusOK).SetString("text/plain", "A")
+	}
+	funcB := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return nil, resterrs.Errorf(http.StatusBadGateway, "B")
+	}
+
+	key := []byte("secret key")
+	toggle := NewDebugToggle(true)
+	tests := []struct {
+		Preds  []DebugPredicate
+		Path   string
+		Header string
+		Toggle bool
+		Expect bool
+	}{
+		{nil, "/a", "", true, true},
+		{[]DebugPredicate{DebugStatus(5)}, "/a", "", true, false},
+		{[]DebugPredicate{DebugStatus(5)}, "/b", "", true, true},
+		{[]DebugPredicate{DebugRoutes("/a")}, "/a", "", true, true},
+		{[]DebugPredicate{DebugRoutes("/a")}, "/b", "", true, false},
+		{[]DebugPredicate{DebugSample(0)}, "/a", "", true, false},
+		{[]DebugPredicate{DebugSample(1)}, "/a", "", true, true},
+		{[]DebugPredicate{DebugHeader("X-Debug", nil)}, "/a", "", true, false},
+		{[]DebugPredicate{DebugHeader("X-Debug", nil)}, "/a", "1", true, true},
+		{[]DebugPredicate{DebugHeader("X-Debug", key)}, "/a", "1", true, false},
+		{[]DebugPredicate{DebugHeader("X-Debug", key)}, "/a", NewDebugToken(key, time.Now().Add(time.Minute)), true, true},
+		{[]DebugPredicate{DebugHeader("X-Debug", key)}, "/a", NewDebugToken(key, time.Now().Add(-time.Minute)), true, false},
+		{[]DebugPredicate{DebugHeader("X-Debug", key)}, "/a", NewDebugToken([]byte("wrong"), time.Now().Add(time.Minute)), true, false},
+		{[]DebugPredicate{toggle}, "/a", "", true, true},
+		{[]DebugPredicate{toggle}, "/a", "", false, false},
+		{[]DebugPredicate{toggle, DebugStatus(5)}, "/b", "", true, true},
+		{[]DebugPredicate{toggle, DebugStatus(5)}, "/b", "", false, false},
+	}
+	for i, e := range tests {
+		toggle.Set(e.Toggle)
+		sink := NewRingSink(1)
+		s, _ := New(WithDebug(true), WithDebugSink(sink), WithDebugPredicate(e.Preds...))
+		s.Add("/a", funcA).Methods("GET")
+		s.Add("/b", funcB).Methods("GET")
+
+		req := mustReq("GET", e.Path, nil)
+		if e.Header != "" {
+			req.Header.Set("X-Debug", e.Header)
+		}
+		s.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, e.Expect, len(sink.Exchanges()) > 0, "#%d", i)
+	}
+
+	// the toggle can be controlled via its handler
+	s, _ := New()
+	s.Add("/debug", toggle.Handle).Methods("GET", "PUT")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("PUT", "/debug", mustEntity("application/json", []byte(`{"enabled":true}`))))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"enabled":true}`, rec.Body.String())
+	assert.True(t, toggle.Enabled())
+}
+
+func TestServiceHAR(t *testing.T) {
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return router.NewResponse(http.StatusCreated).SetString("application/json", `{"ok":true}`)
+	}
+
+	sink := NewHARSink(10)
+	s, _ := New(WithDebug(true), WithDebugSink(sink))
+	s.Add("/a", funcA).Methods("POST")
+	s.Add("/har", sink.Handle).Methods("GET")
+
+	req := mustReq("POST", "http://example.com/a?x=1", mustEntity("application/x-www-form-urlencoded", []byte("name=Bob")))
+	req.Header.Set("Cookie", "session=abc")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/har", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var har HAR
+	err := json.Unmarshal(rec.Body.Bytes(), &har)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.2", har.Log.Version)
+		if assert.Len(t, har.Log.Entries, 1) {
+			e := har.Log.Entries[0]
+			assert.Equal(t, "POST", e.Request.Method)
+			assert.Equal(t, "http://example.com/a?x=1", e.Request.URL)
+			assert.Equal(t, []HARNameValue{{"x", "1"}}, e.Request.QueryString)
+			assert.Empty(t, e.Request.Cookies) // cookies are redacted by default
+			assert.Equal(t, []HARNameValue{{"name", "Bob"}}, e.Request.PostData.Params)
+			assert.Equal(t, http.StatusCreated, e.Response.Status)
+			assert.Equal(t, "application/json", e.Response.Content.MimeType)
+			assert.Equal(t, `{"ok":true}`, e.Response.Content.Text)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "capture.har")
+	if assert.NoError(t, sink.WriteFile(path)) {
+		data, err := os.ReadFile(path)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(data), `"url": "http://example.com/a?x=1"`)
+		}
+	}
+
+	// the file is written in the background, or when the sink is closed
+	bgpath := filepath.Join(t.TempDir(), "background.har")
+	bg := NewHARSink(10).SetFile(bgpath).SetFlushDelay(10 * time.Millisecond)
+	s, _ = New(WithDebug(true), WithDebugSink(bg))
+	s.Add("/a", funcA).Methods("POST")
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("POST", "/a", nil))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(bgpath)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, bg.Close())
+
+	clpath := filepath.Join(t.TempDir(), "close.har")
+	cl := NewHARSink(10).SetFile(clpath).SetFlushDelay(time.Hour)
+	s, _ = New(WithDebug(true), WithDebugSink(cl))
+	s.Add("/a", funcA).Methods("POST")
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("POST", "/a", nil))
+	_, err = os.Stat(clpath)
+	assert.True(t, os.IsNotExist(err), "the file must not be written in the request path")
+	if assert.NoError(t, cl.Close()) {
+		data, err := os.ReadFile(clpath)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(data), `"method": "POST"`)
+		}
+	}
+}
+
+type testMetric struct {
+	sync.Mutex
+	values map[string][]float64
+}
+
+func newTestMetric() *testMetric {
+	return &testMetric{values: make(map[string][]float64)}
+}
+
+func (m *testMetric) record(tags metrics.Tags, v float64) {
+	m.Lock()
+	defer m.Unlock()
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	k := strings.Join(keys, ",")
+	m.values[k] = append(m.values[k], v)
+}
+
+type testSample struct {
+	m    *testMetric
+	tags metrics.Tags
+}
+
+func (s testSample) Observe(v float64) { s.m.record(s.tags, v) }
+func (s testSample) Inc()              { s.m.record(s.tags, 1) }
+func (s testSample) Add(v float64)     { s.m.record(s.tags, v) }
+func (s testSample) Dec()              { s.m.record(s.tags, -1) }
+func (s testSample) Set(v float64)     { s.m.record(s.tags, v) }
+func (s testSample) Sub(v float64)     { s.m.record(s.tags, -v) }
+
+type testSamplerVec struct{ *testMetric }
+
+func (v testSamplerVec) With(t metrics.Tags) metrics.Sampler { return testSample{v.testMetric, t} }
+
+type testCounterVec struct{ *testMetric }
+
+func (v testCounterVec) With(t metrics.Tags) metrics.Counter { return testSample{v.testMetric, t} }
+
+func TestServiceMetrics(t *testing.T) {
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return router.NewResponse(http.StatusOK).SetString("text/plain", "Hello")
+	}
+	funcE := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return nil, resterrs.Errorf(http.StatusBadRequest, "Bad")
+	}
+	funcP := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		panic("Oh no")
+	}
+
+	m := &serviceMetrics{
+		requests: testSamplerVec{newTestMetric()},
+		inflight: testSample{newTestMetric(), nil},
+		reqsize:  testSamplerVec{newTestMetric()},
+		rspsize:  testSamplerVec{newTestMetric()},
+		panics:   testCounterVec{newTestMetric()},
+		errors:   testCounterVec{newTestMetric()},
+	}
+	s, _ := New()
+	s.metrics = m
+	s.Add("/a/{id}", funcA).Methods("GET", "POST")
+	s.Add("/e", funcE).Methods("GET")
+	s.Add("/p", funcP).Methods("GET")
+
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("GET", "/a/1", nil))
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("POST", "/a/2", mustEntity("text/plain", []byte("Hi!"))))
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("BREW", "/a/3", nil))
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("GET", "/e", nil))
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("GET", "/p", nil))
+
+	requests := m.requests.(testSamplerVec).values
+	assert.Len(t, requests["method=GET,route=/a/{id},status=200"], 1)
+	assert.Len(t, requests["method=POST,route=/a/{id},status=200"], 1)
+	assert.Len(t, requests["method=OTHER,route=(not found),status=404"], 1)
+	assert.Len(t, requests["method=GET,route=/e,status=400"], 1)
+	assert.Len(t, requests["method=GET,route=/p,status=500"], 1)
+	assert.Equal(t, []float64{3}, m.reqsize.(testSamplerVec).values["method=POST,route=/a/{id}"])
+	assert.Equal(t, []float64{5}, m.rspsize.(testSamplerVec).values["method=GET,route=/a/{id},status=200"])
+	assert.Equal(t, []float64{1}, m.errors.(testCounterVec).values["method=GET,route=/e"])
+	assert.Equal(t, []float64{1}, m.panics.(testCounterVec).values["method=GET,route=/p"])
+
+	// panics are attributed to the route label of the path which handled them,
+	// including the router error path
+	s.invoke(s.log, routeLabelError, funcP, (*router.Request)(mustReq("GET", "/p", nil)), router.Context{})
+	assert.Equal(t, []float64{1}, m.panics.(testCounterVec).values["method=GET,route="+routeLabelError])
+	assert.Len(t, m.inflight.(testSample).m.values[""], 10) // incremented and decremented for each request
+}
+
+var adminTestRuns atomic.Int32
+
+func TestServiceAdmin(t *testing.T) {
+	var fail atomic.Bool
+	// metrics are registered globally, so each run needs its own namespace
+	m, err := metrics.New(metrics.Config{Namespace: fmt.Sprintf("admin_test_%d", adminTestRuns.Add(1))})
+	if !assert.NoError(t, err) {
+		return
+	}
+	s, _ := New(
+		WithMetrics(m),
+		WithHealthTimeout(50*time.Millisecond),
+		WithHealthCheck("db", func(cxt context.Context) error {
+			if fail.Load() {
+				return errors.New("Connection refused")
+			}
+			return nil
+		}),
+	)
+	s.AddHealthCheck("slow", func(cxt context.Context) error {
+		if fail.Load() {
+			<-cxt.Done()
+			return cxt.Err()
+		}
+		return nil
+	})
+	s.AddHealthCheck("panic", func(cxt context.Context) error {
+		if fail.Load() {
+			panic("Oh no")
+		}
+		return nil
+	})
+	s.MountAdmin("/_admin")
+
+	for _, h := range []http.Handler{s, s.AdminHandler("/")} {
+		prefix := "/_admin"
+		if h != s {
+			prefix = ""
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, mustReq("GET", prefix+"/healthz", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"status":"ok"}`, rec.Body.String())
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, mustReq("GET", prefix+"/metrics", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "rest_requests_in_flight")
+
+		fail.Store(false)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, mustReq("GET", prefix+"/readyz", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		fail.Store(true)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, mustReq("GET", prefix+"/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		var report HealthReport
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report)) {
+			assert.Equal(t, "failing", report.Status)
+			assert.Equal(t, "Connection refused", report.Checks["db"].Error)
+			assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+			assert.Equal(t, "failing", report.Checks["panic"].Status)
+			assert.Equal(t, "panic: Oh no", report.Checks["panic"].Error)
+		}
+	}
+
+	// metrics are only exposed if they are configured
+	s, _ = New()
+	s.MountAdmin("/_admin")
+	for _, h := range []http.Handler{s, s.AdminHandler("/_admin")} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, mustReq("GET", "/_admin/metrics", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, mustReq("HEAD", "/_admin/healthz", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
 func BenchmarkService(b *testing.B) {
 	middleA := func(h router.Handler) router.Handler {
 		return func(req *router.Request, cxt router.Context) (*router.Response, error) {
@@ -190,3 +735,396 @@ func BenchmarkService(b *testing.B) {
 		s.ServeHTTP(rec, reqB)
 	}
 }
+
+func TestServiceTracing(t *testing.T) {
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		Logger(req.Context()).Info("Handling")
+		return router.NewResponse(http.StatusOK).SetString("text/plain", "Hello")
+	}
+	funcB := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return nil, fmt.Errorf("Failed")
+	}
+	funcC := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return nil, resterrs.Errorf(http.StatusUnprocessableEntity, "Invalid")
+	}
+	funcD := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return nil, resterrs.Errorf(http.StatusBadGateway, "Upstream failed")
+	}
+
+	buf := &bytes.Buffer{}
+	exp := tracing.NewMemoryExporter()
+	s, _ := New(WithTracer(tracing.NewTracer(exp)), WithLogger(slog.New(slog.NewTextHandler(buf, nil))))
+	s.Add("/a/{id}", funcA).Methods("GET")
+	s.Add("/b", funcB).Methods("GET")
+	s.Add("/c", funcC).Methods("GET")
+	s.Add("/d", funcD).Methods("GET")
+
+	// a trace propagated by the client is continued
+	req := mustReq("GET", "/a/123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	spans := exp.Spans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "GET /a/{id}", span.Name)
+		assert.Equal(t, tracing.SpanKindServer, span.Kind)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent.String())
+		assert.Equal(t, "/a/{id}", span.Attributes["http.route"])
+		assert.Equal(t, http.StatusOK, span.Attributes["http.response.status_code"])
+		assert.Equal(t, tracing.StatusUnset, span.Status)
+		assert.Contains(t, buf.String(), "trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id="+span.Context.SpanID.String())
+	}
+
+	// a failed request records its error and the trace ID is reported
+	exp.Reset()
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/b", nil))
+	spans = exp.Spans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "GET /b", span.Name)
+		assert.False(t, span.Parent.IsValid())
+		assert.Equal(t, http.StatusInternalServerError, span.Attributes["http.response.status_code"])
+		assert.Equal(t, tracing.StatusError, span.Status)
+		assert.Equal(t, "Failed", span.Message)
+		assert.Contains(t, rec.Body.String(), `"trace_id":"`+span.Context.TraceID.String()+`"`)
+	}
+
+	// client errors do not mark the span as failed, but server errors do
+	for _, e := range []struct {
+		Path   string
+		Code   int
+		Status tracing.StatusCode
+	}{
+		{"/c", http.StatusUnprocessableEntity, tracing.StatusUnset},
+		{"/d", http.StatusBadGateway, tracing.StatusError},
+	} {
+		exp.Reset()
+		s.ServeHTTP(httptest.NewRecorder(), mustReq("GET", e.Path, nil))
+		if spans = exp.Spans(); assert.Len(t, spans, 1, e.Path) {
+			assert.Equal(t, e.Code, spans[0].Attributes["http.response.status_code"], e.Path)
+			assert.Equal(t, e.Status, spans[0].Status, e.Path)
+		}
+	}
+
+	// unmatched requests are named after their method
+	exp.Reset()
+	s.ServeHTTP(httptest.NewRecorder(), mustReq("GET", "/missing", nil))
+	spans = exp.Spans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "GET", spans[0].Name)
+		assert.Equal(t, http.StatusNotFound, spans[0].Attributes["http.response.status_code"])
+		assert.Equal(t, tracing.StatusUnset, spans[0].Status)
+	}
+}
+
+func TestServiceRun(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "service.sock")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	funcA := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		close(started)
+		<-release
+		return router.NewResponse(http.StatusOK).SetString("text/plain", "Drained")
+	}
+
+	var hooks []string
+	s, _ := New(
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithServer(ServerConfig{ShutdownTimeout: time.Second}),
+		WithShutdownHook(func(cxt context.Context) error {
+			hooks = append(hooks, "first")
+			return nil
+		}),
+	)
+	s.OnShutdown(func(cxt context.Context) error {
+		hooks = append(hooks, "second")
+		return errors.New("Failed")
+	})
+	s.Add("/a", funcA).Methods("GET")
+	s.MountAdmin("/admin")
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(cxt context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(cxt, "unix", sock)
+		},
+	}}
+
+	cxt, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(cxt, "unix:"+sock)
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(sock)
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+
+	rsp, err := client.Get("http://service/admin/readyz")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		rsp.Body.Close()
+	}
+
+	// an in-flight request is drained when the service shuts down
+	res := make(chan string)
+	go func() {
+		rsp, err := client.Get("http://service/a")
+		if assert.NoError(t, err) {
+			defer rsp.Body.Close()
+			res <- readAll(rsp.Body)
+		} else {
+			res <- ""
+		}
+	}()
+	<-started
+	cancel()
+	assert.Eventually(t, func() bool {
+		return s.CheckHealth(context.Background()).Draining
+	}, time.Second, time.Millisecond*10)
+	close(release)
+	assert.Equal(t, "Drained", <-res)
+
+	err = <-done
+	assert.ErrorContains(t, err, "Failed")
+	assert.Equal(t, []string{"first", "second"}, hooks)
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServiceRunListen(t *testing.T) {
+	s, _ := New(WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	assert.ErrorContains(t, s.Run(context.Background(), "systemd"), "No sockets were passed by systemd")
+	assert.ErrorContains(t, s.Run(context.Background(), "256.256.256.256:0"), "Could not listen on 256.256.256.256:0")
+}
+
+type slowReader struct {
+	cxt  context.Context
+	data io.Reader
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond * 5)
+	if err := r.cxt.Err(); err != nil {
+		return 0, err
+	}
+	return r.data.Read(p[:min(len(p), 1)])
+}
+
+func TestServiceTimeout(t *testing.T) {
+	late := make(chan struct{})
+	funcSlow := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		<-req.Context().Done()
+		time.Sleep(time.Millisecond * 10)
+		defer close(late)
+		return router.NewResponse(http.StatusOK).SetString("text/plain", "Too late")
+	}
+	funcFast := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		_, ok := req.Context().Deadline()
+		return router.NewResponse(http.StatusOK).SetString("text/plain", fmt.Sprint(ok))
+	}
+	funcSleep := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		time.Sleep(time.Millisecond * 100)
+		return router.NewResponse(http.StatusOK).SetString("text/plain", "Slept")
+	}
+	funcStream := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		rsp := router.NewResponse(http.StatusOK)
+		rsp.Header = http.Header{"Content-Type": []string{"text/plain"}}
+		rsp.Entity = io.NopCloser(&slowReader{req.Context(), strings.NewReader(strings.Repeat("x", 20))})
+		rsp.Streaming = true
+		return rsp, nil
+	}
+
+	s, _ := New(WithTimeout(time.Millisecond*50), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.Add("/slow", funcSlow).Methods("GET")
+	s.Add("/fast", funcFast).Methods("GET")
+	s.Add("/sleep", funcSleep).Methods("GET").With(Timeout(0))
+	s.Add("/stream", funcStream).Methods("GET")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"timeout"`)
+	<-late // the late response is discarded
+
+	tests := []struct {
+		Path   string
+		Expect string
+	}{
+		{"/fast", "true"},
+		{"/sleep", "Slept"},
+		{"/stream", strings.Repeat("x", 20)}, // written after the deadline
+	}
+	for _, e := range tests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, mustReq("GET", e.Path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, e.Path)
+		assert.Equal(t, e.Expect, rec.Body.String(), e.Path)
+	}
+
+	s, _ = New(WithTimeout(time.Millisecond*10), WithTimeoutStatus(http.StatusGatewayTimeout), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.Add("/sleep", funcSleep).Methods("GET")
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, mustReq("GET", "/sleep", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	_, err := New(WithTimeoutStatus(http.StatusInternalServerError))
+	assert.Error(t, err)
+}
+
+func TestTimeoutContext(t *testing.T) {
+	// once the deadline is lifted it is no longer reported and the context is
+	// not canceled when it would have passed
+	cxt := newTimeoutContext(context.Background(), 10*time.Millisecond)
+	_, ok := cxt.Deadline()
+	assert.True(t, ok)
+	assert.True(t, cxt.stop())
+	_, ok = cxt.Deadline()
+	assert.False(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, cxt.Err())
+	cxt.cancel()
+	assert.Equal(t, context.Canceled, cxt.Err())
+
+	// the parent's deadline still applies once the deadline is lifted
+	parent, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	pd, _ := parent.Deadline()
+	cxt = newTimeoutContext(parent, time.Minute)
+	assert.True(t, cxt.stop())
+	d, ok := cxt.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, pd, d)
+
+	// once the deadline has passed it cannot be lifted
+	cxt = newTimeoutContext(context.Background(), time.Millisecond)
+	<-cxt.expired
+	assert.False(t, cxt.stop())
+	assert.Equal(t, context.DeadlineExceeded, cxt.Err())
+	_, ok = cxt.Deadline()
+	assert.True(t, ok)
+
+	// the context expires with the parent's deadline, if it is earlier
+	parent, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cxt = newTimeoutContext(parent, time.Hour)
+	select {
+	case <-cxt.expired:
+		assert.False(t, cxt.stop())
+	case <-time.After(time.Second):
+		assert.Fail(t, "Context did not expire with its parent")
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		Accept string
+		Expect string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, zstd", "zstd"},
+		{"gzip;q=1.0, zstd;q=0.5", "gzip"},
+		{"deflate;q=0.8, GZIP;q=0.9", "gzip"},
+		{"zstd;q=0, gzip", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.5, gzip;q=0", "zstd"},
+		{"br", ""},
+		{"identity", ""},
+		{"identity;q=1, gzip;q=0.5", ""},
+		{"gzip;q=0", ""},
+	}
+	for _, e := range tests {
+		assert.Equal(t, e.Expect, negotiateEncoding(e.Accept, defaultEncodings), e.Accept)
+	}
+}
+
+func TestServiceCompression(t *testing.T) {
+	text := strings.Repeat("Hello, compression. ", 100)
+	funcText := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		rsp, err := router.NewResponse(http.StatusOK).SetString("text/plain", text)
+		if err != nil {
+			return nil, err
+		}
+		rsp.Header.Set("ETag", `"abc"`)
+		return rsp, nil
+	}
+	funcSmall := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return router.NewResponse(http.StatusOK).SetString("text/plain", "Small")
+	}
+	funcBinary := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return router.NewResponse(http.StatusOK).SetBytes("image/png", []byte(text))
+	}
+	funcStream := func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		rsp := router.NewResponse(http.StatusOK)
+		rsp.Header = http.Header{"Content-Type": []string{"text/event-stream"}}
+		rsp.Entity = io.NopCloser(strings.NewReader("data: event\n\n"))
+		return rsp, nil
+	}
+
+	s, _ := New(WithCompression(CompressionConfig{}))
+	s.Add("/text", funcText).Methods("GET", "HEAD")
+	s.Add("/small", funcSmall).Methods("GET")
+	s.Add("/binary", funcBinary).Methods("GET")
+	s.Add("/stream", funcStream).Methods("GET")
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"": func(r io.Reader) (io.Reader, error) { return r, nil },
+		EncodingGzip: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		EncodingDeflate: func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+		EncodingZstd: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			return d, err
+		},
+	}
+
+	tests := []struct {
+		Method   string
+		Path     string
+		Accept   string
+		Encoding string
+		Vary     bool
+		Entity   string
+	}{
+		{"GET", "/text", "", "", true, text},
+		{"GET", "/text", "gzip", EncodingGzip, true, text},
+		{"GET", "/text", "deflate", EncodingDeflate, true, text},
+		{"GET", "/text", "gzip;q=0.5, zstd", EncodingZstd, true, text},
+		{"HEAD", "/text", "gzip", "", true, ""},
+		{"GET", "/small", "gzip", "", true, "Small"},
+		{"GET", "/binary", "gzip", "", false, text},
+		{"GET", "/stream", "gzip", EncodingGzip, true, "data: event\n\n"},
+	}
+	for i := 0; i < 2; i++ { // twice, so that pooled encoders are reused
+		for _, e := range tests {
+			req := mustReq(e.Method, e.Path, nil)
+			if e.Accept != "" {
+				req.Header.Set("Accept-Encoding", e.Accept)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			rsp := rec.Result()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			assert.Equal(t, e.Encoding, rsp.Header.Get("Content-Encoding"), e.Path)
+			assert.Equal(t, e.Vary, rsp.Header.Get("Vary") == "Accept-Encoding", e.Path)
+			if e.Encoding != "" {
+				assert.Equal(t, "", rsp.Header.Get("Content-Length"))
+				if e.Path == "/text" {
+					assert.Equal(t, `W/"abc"`, rsp.Header.Get("ETag"))
+				}
+			}
+			if e.Method == "HEAD" {
+				continue
+			}
+			dec, err := decoders[e.Encoding](rsp.Body)
+			if assert.NoError(t, err) {
+				assert.Equal(t, e.Entity, readAll(dec), e.Path)
+			}
+		}
+	}
+}
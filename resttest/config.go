@@ -0,0 +1,59 @@
+package resttest
+
+import (
+	"net/http"
+)
+
+type Config struct {
+	Header        http.Header // headers set on every replayed request, replacing recorded values
+	IgnoreHeaders []string    // response headers which are not compared
+	IgnoreFields  []string    // JSON response fields, at any depth, which are not compared
+	Redacted      string      // recorded values equal to this are not compared
+}
+
+// The default configuration ignores values which are expected to differ
+// between a recording and a replay
+func DefaultConfig() Config {
+	return Config{
+		IgnoreHeaders: []string{"Date", "Content-Length", "X-Request-Id"},
+		IgnoreFields:  []string{"ref", "request_id"},
+		Redacted:      "[REDACTED]",
+	}
+}
+
+func (c Config) WithOptions(opts []Option) Config {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+type Option func(Config) Config
+
+// Set a header on every replayed request. This is generally necessary to
+// provide credentials, which are redacted when exchanges are captured.
+func WithHeader(k, v string) Option {
+	return func(c Config) Config {
+		if c.Header == nil {
+			c.Header = make(http.Header)
+		}
+		c.Header.Set(k, v)
+		return c
+	}
+}
+
+// Ignore the named response headers in addition to the defaults
+func IgnoreHeaders(n ...string) Option {
+	return func(c Config) Config {
+		c.IgnoreHeaders = append(c.IgnoreHeaders, n...)
+		return c
+	}
+}
+
+// Ignore the named JSON response fields in addition to the defaults
+func IgnoreFields(n ...string) Option {
+	return func(c Config) Config {
+		c.IgnoreFields = append(c.IgnoreFields, n...)
+		return c
+	}
+}
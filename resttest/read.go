@@ -0,0 +1,146 @@
+package resttest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	rest "github.com/bww/go-rest/v2"
+)
+
+// The maximum size of a single line in a JSON capture
+const maxLine = 1 << 26
+
+// Read captured exchanges from a file. Files with the extension .har are
+// read as HTTP archives; others are read as JSON, one exchange per line, as
+// written by rest.NewJSONSink.
+func ReadFile(path string) ([]*rest.DebugExchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".har") {
+		return ReadHAR(f)
+	} else {
+		return ReadJSON(f)
+	}
+}
+
+// Read captured exchanges written as JSON, one exchange per line
+func ReadJSON(r io.Reader) ([]*rest.DebugExchange, error) {
+	var res []*rest.DebugExchange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLine)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		x := &rest.DebugExchange{}
+		err := json.Unmarshal(line, x)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read exchange on line %d: %w", n, err)
+		}
+		res = append(res, x)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Read captured exchanges from an HTTP archive
+func ReadHAR(r io.Reader) ([]*rest.DebugExchange, error) {
+	var har rest.HAR
+	err := json.NewDecoder(r).Decode(&har)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read HAR: %w", err)
+	}
+	res := make([]*rest.DebugExchange, len(har.Log.Entries))
+	for i, e := range har.Log.Entries {
+		res[i], err = fromHAR(e)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read HAR entry #%d: %w", i+1, err)
+		}
+	}
+	return res, nil
+}
+
+func fromHAR(e rest.HAREntry) (*rest.DebugExchange, error) {
+	start, err := time.Parse(time.RFC3339Nano, e.StartedDateTime)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	x := &rest.DebugExchange{
+		Time:      start,
+		Duration:  time.Duration(e.Time * float64(time.Millisecond)),
+		RequestID: e.Comment,
+		Request: rest.DebugRequest{
+			Method: e.Request.Method,
+			Scheme: u.Scheme,
+			Host:   u.Host,
+			URL:    u.RequestURI(),
+			Proto:  e.Request.HTTPVersion,
+			Header: fromHARHeaders(e.Request.Headers),
+		},
+		Response: &rest.DebugResponse{
+			Status: e.Response.Status,
+			Header: fromHARHeaders(e.Response.Headers),
+		},
+	}
+	if p := e.Request.PostData; p != nil {
+		x.Request.Entity = fromHARComment(p.Comment, &rest.DebugEntity{
+			Type: p.MimeType,
+			Data: []byte(p.Text),
+		})
+	}
+	if c := e.Response.Content; c.MimeType != "" {
+		data := []byte(c.Text)
+		if c.Encoding == "base64" {
+			data, err = base64.StdEncoding.DecodeString(c.Text)
+			if err != nil {
+				return nil, err
+			}
+		}
+		x.Response.Entity = fromHARComment(c.Comment, &rest.DebugEntity{
+			Type: c.MimeType,
+			Data: data,
+		})
+	}
+	return x, nil
+}
+
+func fromHARHeaders(h []rest.HARNameValue) http.Header {
+	res := make(http.Header)
+	for _, e := range h {
+		res.Add(e.Name, e.Value)
+	}
+	return res
+}
+
+// Restore the state of an entity described by a comment in a HAR entry
+func fromHARComment(c string, e *rest.DebugEntity) *rest.DebugEntity {
+	switch c {
+	case rest.HARCommentBinary:
+		e.Binary = true
+	case rest.HARCommentStreaming:
+		e.Streaming = true
+	case rest.HARCommentTruncated:
+		e.Truncated = true
+	}
+	return e
+}
@@ -0,0 +1,299 @@
+package resttest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	rest "github.com/bww/go-rest/v2"
+)
+
+// A target against which exchanges are replayed
+type Target interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+type handlerTarget struct {
+	handler http.Handler
+}
+
+// Produce a target which replays requests in-process against a handler,
+// usually a *rest.Service
+func Handler(h http.Handler) Target {
+	return handlerTarget{h}
+}
+
+func (t handlerTarget) Do(req *http.Request) (*http.Response, error) {
+	// fill in what a server would have, as httptest.NewRequest does
+	req.RequestURI = req.URL.RequestURI()
+	req.RemoteAddr = "192.0.2.1:1234"
+	if req.Host == "" {
+		req.Host = "example.com"
+	}
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+type addressTarget struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// Produce a target which replays requests against a server at the provided
+// base URL, e.g., http://localhost:8080
+func Address(base string) (Target, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("Base URL must be absolute: %s", base)
+	}
+	return addressTarget{u, &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // compare redirects as recorded
+		},
+	}}, nil
+}
+
+func (t addressTarget) Do(req *http.Request) (*http.Response, error) {
+	// the request is made to the target, whatever host it was recorded for
+	u := t.base.JoinPath(req.URL.EscapedPath())
+	u.RawQuery = req.URL.RawQuery
+	req.URL = u
+	req.Host = ""
+	req.RequestURI = ""
+	return t.client.Do(req)
+}
+
+// A difference between a recorded response and a replayed response
+type Difference struct {
+	Path   string // what differs; e.g., status, header.Content-Type, or body.user.name
+	Expect string
+	Actual string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", d.Path, d.Expect, d.Actual)
+}
+
+// The result of replaying an exchange
+type Result struct {
+	Exchange    *rest.DebugExchange
+	Differences []Difference
+	Err         error
+}
+
+// Determine if the replayed response matched the recording
+func (r Result) OK() bool {
+	return r.Err == nil && len(r.Differences) == 0
+}
+
+func (r Result) String() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s %s", r.Exchange.Request.Method, r.Exchange.Request.URL)
+	if r.Err != nil {
+		fmt.Fprintf(sb, ": %v", r.Err)
+	}
+	for _, e := range r.Differences {
+		fmt.Fprintf(sb, "\n  - %v", e)
+	}
+	return sb.String()
+}
+
+// Replay exchanges against a target and compare the responses produced with
+// the recorded responses. Exchanges are replayed in order.
+func Replay(t Target, xs []*rest.DebugExchange, opts ...Option) []Result {
+	return ReplayWithConfig(t, xs, DefaultConfig().WithOptions(opts))
+}
+
+// Replay exchanges against a target using the provided configuration
+func ReplayWithConfig(t Target, xs []*rest.DebugExchange, conf Config) []Result {
+	res := make([]Result, len(xs))
+	for i, e := range xs {
+		res[i] = replay(t, e, conf)
+	}
+	return res
+}
+
+// Replay exchanges against a target, reporting every difference as a test
+// error
+func Verify(t testing.TB, target Target, xs []*rest.DebugExchange, opts ...Option) {
+	t.Helper()
+	for _, e := range Replay(target, xs, opts...) {
+		if !e.OK() {
+			t.Error(e.String())
+		}
+	}
+}
+
+func replay(t Target, x *rest.DebugExchange, conf Config) Result {
+	res := Result{Exchange: x}
+	req, err := newRequest(x, conf)
+	if err != nil {
+		res.Err = fmt.Errorf("Could not create request: %w", err)
+		return res
+	}
+	rsp, err := t.Do(req)
+	if err != nil {
+		res.Err = fmt.Errorf("Could not perform request: %w", err)
+		return res
+	}
+	defer rsp.Body.Close()
+	data, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		res.Err = fmt.Errorf("Could not read response: %w", err)
+		return res
+	}
+	if x.Response != nil {
+		res.Differences = compare(*x.Response, rsp, data, conf)
+	}
+	return res
+}
+
+func newRequest(x *rest.DebugExchange, conf Config) (*http.Request, error) {
+	var body io.Reader
+	if e := x.Request.Entity; e != nil {
+		if e.Binary || e.Truncated {
+			return nil, fmt.Errorf("Request entity was not fully captured")
+		}
+		body = bytes.NewReader(e.Data)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), x.Request.Method, x.Request.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	if x.Request.Host != "" {
+		req.Host = x.Request.Host
+	}
+	for k, v := range x.Request.Header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	req.Header.Del("Content-Length")  // this may no longer be accurate
+	req.Header.Del("Accept-Encoding") // entities are captured uncompressed, so compare them that way
+	for k, v := range conf.Header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+func compare(expect rest.DebugResponse, rsp *http.Response, data []byte, conf Config) []Difference {
+	var diffs []Difference
+	if expect.Status != rsp.StatusCode {
+		diffs = append(diffs, Difference{"status", fmt.Sprint(expect.Status), fmt.Sprint(rsp.StatusCode)})
+	}
+
+	ignore := make(map[string]struct{})
+	for _, e := range conf.IgnoreHeaders {
+		ignore[http.CanonicalHeaderKey(e)] = struct{}{}
+	}
+	keys := make([]string, 0, len(expect.Header))
+	for k := range expect.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := ignore[http.CanonicalHeaderKey(k)]; ok {
+			continue
+		}
+		v, a := strings.Join(expect.Header[k], ", "), strings.Join(rsp.Header.Values(k), ", ")
+		if v != a && v != conf.Redacted {
+			diffs = append(diffs, Difference{"header." + k, v, a})
+		}
+	}
+
+	e := expect.Entity
+	if e == nil || e.Binary || e.Streaming || e.Truncated {
+		return diffs // the entity was not captured in full, so it can't be compared
+	}
+	if isJSON(e.Type) {
+		var ev, av interface{}
+		if err := json.Unmarshal(e.Data, &ev); err == nil {
+			if err := json.Unmarshal(data, &av); err != nil {
+				return append(diffs, Difference{"body", string(e.Data), string(data)})
+			}
+			fields := make(map[string]struct{})
+			for _, f := range conf.IgnoreFields {
+				fields[f] = struct{}{}
+			}
+			return append(diffs, compareJSON("body", ev, av, fields, conf.Redacted)...)
+		}
+	}
+	if !bytes.Equal(e.Data, data) {
+		diffs = append(diffs, Difference{"body", string(e.Data), string(data)})
+	}
+	return diffs
+}
+
+func compareJSON(path string, expect, actual interface{}, ignore map[string]struct{}, redacted string) []Difference {
+	switch ev := expect.(type) {
+	case map[string]interface{}:
+		av, ok := actual.(map[string]interface{})
+		if !ok {
+			return []Difference{{path, jsonString(expect), jsonString(actual)}}
+		}
+		keys := make(map[string]struct{})
+		for k := range ev {
+			keys[k] = struct{}{}
+		}
+		for k := range av {
+			keys[k] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			if _, ok := ignore[k]; !ok {
+				sorted = append(sorted, k)
+			}
+		}
+		sort.Strings(sorted)
+		var diffs []Difference
+		for _, k := range sorted {
+			diffs = append(diffs, compareJSON(path+"."+k, ev[k], av[k], ignore, redacted)...)
+		}
+		return diffs
+	case []interface{}:
+		av, ok := actual.([]interface{})
+		if !ok || len(ev) != len(av) {
+			return []Difference{{path, jsonString(expect), jsonString(actual)}}
+		}
+		var diffs []Difference
+		for i := range ev {
+			diffs = append(diffs, compareJSON(fmt.Sprintf("%s[%d]", path, i), ev[i], av[i], ignore, redacted)...)
+		}
+		return diffs
+	default:
+		if s, ok := expect.(string); ok && s == redacted {
+			return nil
+		}
+		if e, a := jsonString(expect), jsonString(actual); e != a {
+			return []Difference{{path, e, a}}
+		}
+		return nil
+	}
+}
+
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func isJSON(t string) bool {
+	m, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return false
+	}
+	return m == "application/json" || strings.HasSuffix(m, "+json")
+}
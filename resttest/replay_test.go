@@ -0,0 +1,119 @@
+package resttest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	rest "github.com/bww/go-rest/v2"
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newService(t *testing.T, name string, opts ...rest.Option) *rest.Service {
+	s, err := rest.New(opts...)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s.Add("/users/{id}", func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return router.NewResponse(http.StatusOK).SetJSON(map[string]interface{}{
+			"id":   cxt.Vars["id"],
+			"name": name,
+		})
+	}).Methods("GET")
+	s.Add("/missing", func(req *router.Request, cxt router.Context) (*router.Response, error) {
+		return nil, resterrs.Errorf(http.StatusNotFound, "Not found")
+	}).Methods("GET")
+	return s
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		Sink func(*bytes.Buffer) rest.DebugSink
+		Read func(*bytes.Buffer) ([]*rest.DebugExchange, error)
+		Done func(rest.DebugSink, *bytes.Buffer)
+	}{
+		{
+			Sink: func(b *bytes.Buffer) rest.DebugSink { return rest.NewJSONSink(b) },
+			Read: func(b *bytes.Buffer) ([]*rest.DebugExchange, error) { return ReadJSON(b) },
+		},
+		{
+			Sink: func(b *bytes.Buffer) rest.DebugSink { return rest.NewHARSink(10) },
+			Read: func(b *bytes.Buffer) ([]*rest.DebugExchange, error) { return ReadHAR(b) },
+			Done: func(k rest.DebugSink, b *bytes.Buffer) { k.(*rest.HARSink).WriteTo(b) },
+		},
+	}
+	for _, e := range tests {
+		buf := &bytes.Buffer{}
+		sink := e.Sink(buf)
+		s := newService(t, "Bob", rest.WithDebug(true), rest.WithDebugSink(sink))
+		for _, p := range []string{"/users/1", "/missing"} {
+			req := httptest.NewRequest("GET", p, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			s.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		if e.Done != nil {
+			e.Done(sink, buf)
+		}
+
+		xs, err := e.Read(buf)
+		if !assert.NoError(t, err) || !assert.Len(t, xs, 2) {
+			continue
+		}
+
+		// replaying against an equivalent service produces no differences; the
+		// ref and request ID differ but are ignored by default
+		Verify(t, Handler(newService(t, "Bob")), xs, WithHeader("Authorization", "Bearer secret"))
+
+		// replaying against a service which behaves differently is reported
+		res := Replay(Handler(newService(t, "Jim")), xs)
+		if assert.Len(t, res, 2) {
+			assert.Equal(t, []Difference{{"body.name", `"Bob"`, `"Jim"`}}, res[0].Differences)
+			assert.True(t, res[1].OK())
+			assert.True(t, strings.HasPrefix(res[0].String(), "GET /users/1"))
+		}
+
+		// ignored fields are not compared
+		res = Replay(Handler(newService(t, "Jim")), xs, IgnoreFields("name"))
+		assert.True(t, res[0].OK())
+	}
+
+	// entities are compared uncompressed, even if the recorded request accepted
+	// a compressed response
+	buf := &bytes.Buffer{}
+	s := newService(t, "Bob", rest.WithDebug(true), rest.WithDebugSink(rest.NewJSONSink(buf)))
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	xs, err := ReadJSON(buf)
+	if assert.NoError(t, err) {
+		Verify(t, Handler(newService(t, "Bob", rest.WithCompression(rest.CompressionConfig{MinSize: 1}))), xs)
+	}
+
+	// a malformed recording is reported rather than replayed
+	res := Replay(Handler(newService(t, "Bob")), []*rest.DebugExchange{{Request: rest.DebugRequest{Method: "BAD METHOD", URL: "/users/1"}}})
+	if assert.Len(t, res, 1) {
+		assert.Error(t, res[0].Err)
+	}
+
+	// requests are replayed against an address under its base path, rather
+	// than the host they were recorded for
+	var host, uri string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, uri = req.Host, req.RequestURI
+	}))
+	defer srv.Close()
+	target, err := Address(srv.URL + "/api")
+	if assert.NoError(t, err) {
+		x := &rest.DebugExchange{Request: rest.DebugRequest{Method: "GET", Host: "prod.example.com", URL: "https://prod.example.com/users/1?a=b"}}
+		res := Replay(target, []*rest.DebugExchange{x})
+		if assert.Len(t, res, 1) && assert.NoError(t, res[0].Err) {
+			assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), host)
+			assert.Equal(t, "/api/users/1?a=b", uri)
+		}
+	}
+}
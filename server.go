@@ -0,0 +1,291 @@
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bww/go-util/v1/ext"
+)
+
+// Server defaults
+const (
+	defaultAddr              = ":8080"
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+// Address schemes understood by Run
+const (
+	unixAddrPrefix    = "unix:"
+	systemdAddr       = "systemd"
+	systemdAddrPrefix = "systemd:"
+	systemdFirstFD    = 3
+)
+
+// Server configuration, used by Run. Zero durations are replaced by defaults;
+// use a negative duration to disable a timeout.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration // the default is 10s
+	ReadTimeout       time.Duration // the default is no timeout; the read header timeout bounds slow clients
+	WriteTimeout      time.Duration // the default is 60s; it is lifted for streaming responses
+	IdleTimeout       time.Duration // the default is 120s
+	ShutdownTimeout   time.Duration // the time permitted for in-flight requests to drain; the default is 30s
+	DrainDelay        time.Duration // the time to wait after readiness fails before draining begins
+
+	TLSCertFile string      // the certificate file; if set, every listener serves TLS
+	TLSKeyFile  string      // the private key file
+	TLSConfig   *tls.Config // optional TLS configuration
+
+	// The signals which begin a graceful shutdown; the default is SIGTERM and
+	// SIGINT
+	Signals []os.Signal
+}
+
+func (c ServerConfig) timeout(d, dflt time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return ext.Coalesce(d, dflt)
+}
+
+// A shutdown hook is run after the service has stopped serving requests
+type ShutdownHook func(context.Context) error
+
+// Register a hook which is run when the service shuts down. Hooks are run in
+// the order they are registered, after in-flight requests have drained.
+func (s *Service) OnShutdown(hook ShutdownHook) {
+	s.hooks.Lock()
+	defer s.hooks.Unlock()
+	s.hooks.list = append(s.hooks.list, hook)
+}
+
+type shutdownHooks struct {
+	sync.Mutex
+	list []ShutdownHook
+}
+
+// Run the service on the provided addresses until the context is canceled
+// or a shutdown signal is received, then shut down gracefully. Addresses are
+// one of:
+//
+//   - a TCP address, like "localhost:8080" or ":8080",
+//   - a Unix socket, like "unix:/run/service.sock",
+//   - "systemd", for every socket passed via systemd socket activation, or
+//   - "systemd:name", for the activated sockets with the provided name.
+//
+// If no address is provided the service listens on :8080.
+//
+// When shutdown begins the service is marked as draining, which causes the
+// readiness endpoint to fail. After the configured drain delay the service
+// stops accepting connections and waits for in-flight requests to complete
+// until the shutdown timeout elapses, after which remaining connections are
+// closed. Finally, shutdown hooks are run in order.
+//
+// Run returns nil after a graceful shutdown, or an error describing anything
+// that failed.
+func (s *Service) Run(cxt context.Context, addrs ...string) error {
+	conf := s.server
+	if len(addrs) == 0 {
+		addrs = []string{defaultAddr}
+	}
+
+	var ls []net.Listener
+	for _, e := range addrs {
+		l, err := listen(e)
+		if err != nil {
+			closeListeners(ls)
+			return fmt.Errorf("Could not listen on %s: %w", e, err)
+		}
+		ls = append(ls, l...)
+	}
+
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: conf.timeout(conf.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       conf.timeout(conf.ReadTimeout, 0),
+		WriteTimeout:      conf.timeout(conf.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       conf.timeout(conf.IdleTimeout, defaultIdleTimeout),
+		TLSConfig:         conf.TLSConfig,
+		ErrorLog:          slog.NewLogLogger(s.log.Handler(), slog.LevelWarn),
+	}
+
+	errs := make(chan error, len(ls))
+	for _, l := range ls {
+		s.log.With("addr", l.Addr().String()).Info("Listening")
+		go func(l net.Listener) {
+			var err error
+			if conf.TLSCertFile != "" || conf.TLSConfig != nil {
+				err = srv.ServeTLS(l, conf.TLSCertFile, conf.TLSKeyFile)
+			} else {
+				err = srv.Serve(l)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(l)
+	}
+
+	notify := conf.Signals
+	if len(notify) == 0 {
+		notify = []os.Signal{syscall.SIGTERM, os.Interrupt}
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, notify...)
+	defer signal.Stop(sigs)
+
+	var res []error
+	select {
+	case <-cxt.Done():
+		s.log.Info("Shutting down")
+	case sig := <-sigs:
+		s.log.With("signal", sig.String()).Info("Shutting down")
+	case err := <-errs:
+		s.log.With("because", err.Error()).Error("Server failed; shutting down")
+		res = append(res, err)
+	}
+
+	s.SetDraining(true)
+	if conf.DrainDelay > 0 {
+		time.Sleep(conf.DrainDelay)
+	}
+
+	timeout := conf.timeout(conf.ShutdownTimeout, defaultShutdownTimeout)
+	if err := s.drain(srv, timeout); err != nil {
+		res = append(res, err)
+	}
+	if err := s.runShutdownHooks(timeout); err != nil {
+		res = append(res, err)
+	}
+	return errors.Join(res...)
+}
+
+// Stop accepting connections and wait for in-flight requests to complete. If
+// they do not complete within the timeout, remaining connections are closed.
+func (s *Service) drain(srv *http.Server, timeout time.Duration) error {
+	cxt, cancel := context.Background(), func() {}
+	if timeout > 0 {
+		cxt, cancel = context.WithTimeout(cxt, timeout)
+	}
+	defer cancel()
+	err := srv.Shutdown(cxt)
+	if err == nil {
+		return nil
+	}
+	s.log.With("because", err.Error()).Warn("Requests did not drain; closing connections")
+	srv.Close()
+	return fmt.Errorf("Could not drain requests: %w", err)
+}
+
+// Run shutdown hooks in order. Every hook is run, even if one fails; each is
+// permitted the provided timeout to complete.
+func (s *Service) runShutdownHooks(timeout time.Duration) error {
+	s.hooks.Lock()
+	hooks := append([]ShutdownHook(nil), s.hooks.list...)
+	s.hooks.Unlock()
+
+	var errs []error
+	for i, e := range hooks {
+		cxt, cancel := context.Background(), func() {}
+		if timeout > 0 {
+			cxt, cancel = context.WithTimeout(cxt, timeout)
+		}
+		err := e(cxt)
+		cancel()
+		if err != nil {
+			s.log.With("hook", i, "because", err.Error()).Error("Shutdown hook failed")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Create the listeners for an address
+func listen(addr string) ([]net.Listener, error) {
+	switch {
+	case addr == systemdAddr:
+		return systemdListeners("")
+	case strings.HasPrefix(addr, systemdAddrPrefix):
+		return systemdListeners(strings.TrimPrefix(addr, systemdAddrPrefix))
+	case strings.HasPrefix(addr, unixAddrPrefix):
+		l, err := listenUnix(strings.TrimPrefix(addr, unixAddrPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	default:
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+}
+
+// Listen on a Unix socket, removing a stale socket left at the path by a
+// previous process, if any
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Socket is in use: %s", path)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// Obtain the listeners passed via systemd socket activation. If a name is
+// provided, only sockets with that name are returned.
+func systemdListeners(name string) ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("No sockets were passed by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("No sockets were passed by systemd")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var ls []net.Listener
+	for i := 0; i < n; i++ {
+		var fdname string
+		if i < len(names) {
+			fdname = names[i]
+		}
+		if name != "" && name != fdname {
+			continue
+		}
+		f := os.NewFile(uintptr(systemdFirstFD+i), fdname)
+		l, err := net.FileListener(f)
+		f.Close() // the listener holds its own descriptor
+		if err != nil {
+			closeListeners(ls)
+			return nil, fmt.Errorf("Invalid socket passed by systemd: %w", err)
+		}
+		ls = append(ls, l)
+	}
+	if len(ls) == 0 {
+		return nil, fmt.Errorf("No socket named %q was passed by systemd", name)
+	}
+	return ls, nil
+}
+
+func closeListeners(ls []net.Listener) {
+	for _, e := range ls {
+		e.Close()
+	}
+}
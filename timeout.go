@@ -0,0 +1,193 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	resterrs "github.com/bww/go-rest/v2/errors"
+
+	"github.com/bww/go-router/v2"
+)
+
+// The route attribute which carries a per-route timeout
+const timeoutAttr = "github.com/bww/go-rest.Timeout"
+
+// The default status of the response produced when a request times out
+const defaultTimeoutStatus = http.StatusServiceUnavailable
+
+// Timeout is a route option which sets the time permitted for the route's
+// handler to produce a response, overriding the service's timeout. A zero
+// duration disables the timeout for the route.
+//
+//	s.Add("/reports", handleReports).Methods("GET").With(rest.Timeout(time.Minute))
+func Timeout(d time.Duration) router.RouteOption {
+	return func(r *router.Route) *router.Route {
+		return r.Attr(timeoutAttr, d)
+	}
+}
+
+// Determine the timeout for a route
+func routeTimeout(cxt router.Context, dflt time.Duration) time.Duration {
+	if d, ok := cxt.Attrs[timeoutAttr].(time.Duration); ok {
+		return d
+	}
+	return dflt
+}
+
+// Invoke a handler with a deadline. If the handler has not returned by the
+// deadline, the request context is canceled and a timeout error is produced
+// in place of its response; whatever the handler eventually returns is
+// discarded.
+//
+// Once the handler returns the deadline is lifted, so a streaming response
+// may continue to be written after it passes. The request context remains
+// valid until the response entity is closed.
+func (s *Service) invokeWithTimeout(log *slog.Logger, rlabel string, hdl router.Handler, req *router.Request, cxt router.Context, d time.Duration) (*router.Response, error) {
+	tcx := newTimeoutContext(req.Context(), d)
+	req = (*router.Request)((*http.Request)(req).WithContext(tcx))
+
+	type result struct {
+		rsp *router.Response
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		rsp, err := s.invoke(log, rlabel, hdl, req, cxt)
+		res <- result{rsp, err}
+	}()
+
+	select {
+	case r := <-res:
+		if tcx.stop() {
+			if r.rsp == nil || r.rsp.Entity == nil {
+				tcx.cancel()
+			} else {
+				r.rsp.Entity = &cancelCloser{r.rsp.Entity, tcx.cancel}
+			}
+			return r.rsp, r.err
+		}
+		// the deadline passed just as the handler returned, so its context
+		// has been canceled and the response can't be relied upon
+		discardResponse(r.rsp)
+	case <-tcx.expired:
+		go func() {
+			discardResponse((<-res).rsp)
+		}()
+	}
+	log.With("timeout", d.String()).Warn("Handler timed out")
+	return s.errorResponse(req, resterrs.Errorf(s.tstatus, "Request timed out").SetCode(resterrs.CodeTimeout)), nil
+}
+
+// Discard a response which will not be written
+func discardResponse(rsp *router.Response) {
+	if rsp != nil && rsp.Entity != nil {
+		rsp.Entity.Close()
+	}
+}
+
+// Cancels a context when the entity it wraps is closed
+type cancelCloser struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (c *cancelCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// A context with a deadline which can be lifted once it is no longer relevant,
+// which is not possible with the standard deadline context
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	expired  chan struct{}
+	cancelf  context.CancelCauseFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool // the deadline has been lifted
+	fired   bool // the deadline has passed
+}
+
+func newTimeoutContext(parent context.Context, d time.Duration) *timeoutContext {
+	cxt, cancel := context.WithCancelCause(parent)
+	deadline := time.Now().Add(d)
+	if pd, ok := parent.Deadline(); ok && pd.Before(deadline) {
+		deadline = pd
+	}
+	t := &timeoutContext{
+		Context:  cxt,
+		deadline: deadline,
+		expired:  make(chan struct{}),
+		cancelf:  cancel,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// the timer is armed for the earlier deadline, so that the timeout is
+	// reported as such even if it is the parent's deadline which passes
+	t.timer = time.AfterFunc(time.Until(deadline), t.expire)
+	return t
+}
+
+// Expire the context, unless the deadline has been lifted
+func (c *timeoutContext) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopped {
+		c.fire()
+	}
+}
+
+func (c *timeoutContext) fire() {
+	if !c.fired {
+		c.fired = true
+		close(c.expired)
+		c.cancelf(context.DeadlineExceeded)
+	}
+}
+
+// Once the deadline is lifted only the parent's deadline, if any, applies
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return c.Context.Deadline()
+	}
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && context.Cause(c.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Lift the deadline. This returns false if the deadline has already passed,
+// in which case the context has been canceled. The deadline may pass before
+// the timer fires, e.g., when the handler returns because the parent's
+// deadline passed first, so the time is checked as well.
+func (c *timeoutContext) stop() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopped && !time.Now().Before(c.deadline) {
+		c.fire()
+	}
+	if c.fired {
+		return false
+	}
+	c.stopped = true
+	c.timer.Stop()
+	return true
+}
+
+func (c *timeoutContext) cancel() {
+	c.stop()
+	c.cancelf(context.Canceled)
+}
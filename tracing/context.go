@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// W3C trace context headers
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+const (
+	traceparentVersion = "00"
+	flagSampled        = 0x01
+)
+
+var (
+	ErrMalformed = errors.New("Malformed traceparent")
+	ErrInvalidID = errors.New("Invalid trace or span ID")
+)
+
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		rand.Read(t[:])
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		rand.Read(s[:])
+	}
+	return s
+}
+
+// A span context identifies a span within a trace and carries the state that
+// is propagated between services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string // the opaque tracestate value, propagated as-is
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+func (c SpanContext) Sampled() bool {
+	return c.Flags&flagSampled != 0
+}
+
+// Produce the traceparent header value for this context
+func (c SpanContext) Traceparent() string {
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, c.TraceID, c.SpanID, c.Flags)
+}
+
+// Parse a traceparent header value
+func ParseTraceparent(v string) (SpanContext, error) {
+	var c SpanContext
+	p := strings.Split(strings.TrimSpace(v), "-")
+	if len(p) < 4 || len(p[0]) != 2 || len(p[1]) != 32 || len(p[2]) != 16 || len(p[3]) != 2 {
+		return c, ErrMalformed
+	}
+	if p[0] == "ff" || (p[0] == traceparentVersion && len(p) != 4) {
+		return c, ErrMalformed // version ff is invalid; version 00 has exactly four fields
+	}
+	if _, err := hex.Decode(c.TraceID[:], []byte(p[1])); err != nil || strings.ToLower(p[1]) != p[1] {
+		return c, ErrMalformed
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(p[2])); err != nil || strings.ToLower(p[2]) != p[2] {
+		return c, ErrMalformed
+	}
+	var f [1]byte
+	if _, err := hex.Decode(f[:], []byte(p[3])); err != nil {
+		return c, ErrMalformed
+	}
+	c.Flags = f[0]
+	if !c.IsValid() {
+		return c, ErrInvalidID
+	}
+	return c, nil
+}
+
+// Extract the span context propagated in a request header. If the header
+// does not carry a valid context, the result is invalid.
+func Extract(h http.Header) SpanContext {
+	c, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}
+	}
+	c.State = h.Get(TracestateHeader)
+	return c
+}
+
+// Inject the span context carried by a context into a header, so that it is
+// propagated to a downstream service. If the context does not carry a span,
+// the header is not modified.
+func Inject(cxt context.Context, h http.Header) {
+	span := FromContext(cxt)
+	if span == nil {
+		return
+	}
+	sc := span.Context()
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.State != "" {
+		h.Set(TracestateHeader, sc.State)
+	}
+}
+
+type contextKey struct{}
+
+// Derive a context which carries the provided span
+func NewContext(cxt context.Context, span *Span) context.Context {
+	return context.WithValue(cxt, contextKey{}, span)
+}
+
+// Obtain the span carried by a context, if any
+func FromContext(cxt context.Context) *Span {
+	span, _ := cxt.Value(contextKey{}).(*Span)
+	return span
+}
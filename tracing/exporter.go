@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"sync"
+)
+
+// An exporter receives spans once they have ended. Exporters must not block;
+// those which deliver spans remotely are expected to do so asynchronously.
+type Exporter interface {
+	Export(*SpanData)
+}
+
+// An exporter which retains spans in memory; this is mainly useful in tests
+type MemoryExporter struct {
+	sync.Mutex
+	spans []*SpanData
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+func (e *MemoryExporter) Export(s *SpanData) {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+// Obtain the spans exported so far, in the order they ended
+func (e *MemoryExporter) Spans() []*SpanData {
+	e.Lock()
+	defer e.Unlock()
+	return append([]*SpanData(nil), e.spans...)
+}
+
+// Discard the spans exported so far
+func (e *MemoryExporter) Reset() {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,373 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOTLPEndpoint  = "http://localhost:4318/v1/traces"
+	defaultOTLPService   = "unknown_service"
+	defaultOTLPBatchSize = 512
+	defaultOTLPQueueSize = 2048
+	defaultOTLPInterval  = 5 * time.Second
+	defaultOTLPTimeout   = 10 * time.Second
+	otlpScopeName        = "github.com/bww/go-rest/v2"
+)
+
+// OTLP exporter configuration
+type OTLPConfig struct {
+	Endpoint  string        // the traces endpoint; the default targets a local collector
+	Service   string        // the service.name resource attribute
+	Header    http.Header   // headers sent with every export request
+	Client    *http.Client  // the client used to export; the default has a 10s timeout
+	BatchSize int           // the maximum number of spans exported in one request
+	QueueSize int           // the maximum number of spans queued; spans are dropped when full
+	Interval  time.Duration // the maximum time a span waits in the queue before it is exported
+	OnError   func(error)   // called when an export fails; the default logs a warning
+}
+
+type OTLPOption func(OTLPConfig) OTLPConfig
+
+func (c OTLPConfig) WithOptions(opts []OTLPOption) OTLPConfig {
+	for _, o := range opts {
+		c = o(c)
+	}
+	return c
+}
+
+func WithEndpoint(u string) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		c.Endpoint = u
+		return c
+	}
+}
+
+func WithServiceName(n string) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		c.Service = n
+		return c
+	}
+}
+
+func WithHeader(k, v string) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		if c.Header == nil {
+			c.Header = make(http.Header)
+		}
+		c.Header.Add(k, v)
+		return c
+	}
+}
+
+func WithClient(v *http.Client) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		c.Client = v
+		return c
+	}
+}
+
+func WithBatchSize(n int) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		c.BatchSize = n
+		return c
+	}
+}
+
+func WithQueueSize(n int) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		c.QueueSize = n
+		return c
+	}
+}
+
+func WithInterval(d time.Duration) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		c.Interval = d
+		return c
+	}
+}
+
+func WithErrorHandler(f func(error)) OTLPOption {
+	return func(c OTLPConfig) OTLPConfig {
+		c.OnError = f
+		return c
+	}
+}
+
+// An exporter which delivers spans to a collector using OTLP over HTTP with
+// JSON encoding. Spans are queued and exported in batches in the background;
+// the exporter must be shut down to deliver any spans which remain queued.
+type OTLPExporter struct {
+	conf  OTLPConfig
+	queue chan *SpanData
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func NewOTLPExporter(opts ...OTLPOption) *OTLPExporter {
+	conf := OTLPConfig{
+		Endpoint:  defaultOTLPEndpoint,
+		Service:   defaultOTLPService,
+		BatchSize: defaultOTLPBatchSize,
+		QueueSize: defaultOTLPQueueSize,
+		Interval:  defaultOTLPInterval,
+	}.WithOptions(opts)
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: defaultOTLPTimeout}
+	}
+	if conf.OnError == nil {
+		conf.OnError = func(err error) {
+			slog.Warn("Could not export spans", "because", err.Error())
+		}
+	}
+	e := &OTLPExporter{
+		conf:  conf,
+		queue: make(chan *SpanData, conf.QueueSize),
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Queue a span for export. If the queue is full or the exporter has been shut
+// down, the span is dropped.
+func (e *OTLPExporter) Export(s *SpanData) {
+	select {
+	case <-e.stop:
+	case e.queue <- s:
+	default:
+	}
+}
+
+// Export any queued spans, waiting until they have been delivered or the
+// context ends
+func (e *OTLPExporter) Flush(cxt context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case <-e.done:
+		return nil
+	case e.flush <- ack:
+	case <-cxt.Done():
+		return cxt.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-cxt.Done():
+		return cxt.Err()
+	}
+}
+
+// Shut down the exporter, delivering any queued spans. Spans exported after
+// the exporter has been shut down are dropped.
+func (e *OTLPExporter) Shutdown(cxt context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-cxt.Done():
+		return cxt.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	tick := time.NewTicker(e.conf.Interval)
+	defer tick.Stop()
+
+	batch := make([]*SpanData, 0, e.conf.BatchSize)
+	send := func() {
+		if len(batch) > 0 {
+			if err := e.send(batch); err != nil {
+				e.conf.OnError(err)
+			}
+			batch = batch[:0]
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-e.queue:
+				if batch = append(batch, s); len(batch) >= e.conf.BatchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) >= e.conf.BatchSize {
+				send()
+			}
+		case <-tick.C:
+			send()
+		case ack := <-e.flush:
+			drain()
+			close(ack)
+		case <-e.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*SpanData) error {
+	data, err := json.Marshal(newOTLPRequest(e.conf.Service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.conf.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.conf.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := e.conf.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, rsp.Body)
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("Collector responded with status: %s", rsp.Status)
+	}
+	return nil
+}
+
+// The JSON encoding of an OTLP ExportTraceServiceRequest. Trace and span IDs
+// are hex encoded and 64-bit integers are encoded as strings, as required by
+// the OTLP/JSON specification.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	TraceState   string         `json:"traceState,omitempty"`
+	Name         string         `json:"name"`
+	Kind         SpanKind       `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	Status       otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Bool   *bool    `json:"boolValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"`
+	Double *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPRequest(service string, spans []*SpanData) otlpRequest {
+	conv := make([]otlpSpan, len(spans))
+	for i, e := range spans {
+		s := otlpSpan{
+			TraceID:    e.Context.TraceID.String(),
+			SpanID:     e.Context.SpanID.String(),
+			TraceState: e.Context.State,
+			Name:       e.Name,
+			Kind:       e.Kind,
+			Start:      strconv.FormatInt(e.Start.UnixNano(), 10),
+			End:        strconv.FormatInt(e.End.UnixNano(), 10),
+			Attributes: otlpAttributes(e.Attributes),
+			Status:     otlpStatus{Code: e.Status, Message: e.Message},
+		}
+		if e.Parent.IsValid() {
+			s.ParentSpanID = e.Parent.String()
+		}
+		conv[i] = s
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource:   otlpResource{Attributes: otlpAttributes(map[string]any{"service.name": service})},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: otlpScopeName}, Spans: conv}},
+			},
+		},
+	}
+}
+
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kv := make([]otlpKeyValue, len(keys))
+	for i, k := range keys {
+		kv[i] = otlpKeyValue{Key: k, Value: newOTLPValue(attrs[k])}
+	}
+	return kv
+}
+
+func newOTLPValue(v any) otlpValue {
+	switch c := v.(type) {
+	case string:
+		return otlpValue{String: &c}
+	case bool:
+		return otlpValue{Bool: &c}
+	case int:
+		s := strconv.FormatInt(int64(c), 10)
+		return otlpValue{Int: &s}
+	case int64:
+		s := strconv.FormatInt(c, 10)
+		return otlpValue{Int: &s}
+	case float64:
+		return otlpValue{Double: &c}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{String: &s}
+	}
+}
@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// Span kinds, with values matching OpenTelemetry
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+// Span status codes, with values matching OpenTelemetry
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// A span describes an operation within a trace
+type Span struct {
+	sync.Mutex
+	tracer  *Tracer
+	name    string
+	kind    SpanKind
+	context SpanContext
+	parent  SpanID
+	start   time.Time
+	end     time.Time
+	attrs   map[string]any
+	status  StatusCode
+	message string
+	ended   bool
+}
+
+// A snapshot of a span, as provided to exporters
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]any
+	Status     StatusCode
+	Message    string
+}
+
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+func (s *Span) Status() StatusCode {
+	s.Lock()
+	defer s.Unlock()
+	return s.status
+}
+
+func (s *Span) SetName(n string) *Span {
+	s.Lock()
+	defer s.Unlock()
+	s.name = n
+	return s
+}
+
+func (s *Span) SetAttr(k string, v any) *Span {
+	s.Lock()
+	defer s.Unlock()
+	s.attrs[k] = v
+	return s
+}
+
+func (s *Span) SetStatus(c StatusCode, m string) *Span {
+	s.Lock()
+	defer s.Unlock()
+	s.status, s.message = c, m
+	return s
+}
+
+// Record an error on the span, marking its status as an error
+func (s *Span) SetError(err error) *Span {
+	s.Lock()
+	defer s.Unlock()
+	s.status, s.message = StatusError, err.Error()
+	s.attrs["error.type"] = fmt.Sprintf("%T", err)
+	return s
+}
+
+// End the span. A sampled span is provided to the tracer's exporter when it
+// ends. Ending a span more than once has no effect.
+func (s *Span) End() {
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	data := s.data()
+	s.Unlock()
+	if s.context.Sampled() && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+func (s *Span) data() *SpanData {
+	attrs := make(map[string]any, len(s.attrs))
+	for k, v := range s.attrs {
+		attrs[k] = v
+	}
+	return &SpanData{
+		Name:       s.name,
+		Kind:       s.kind,
+		Context:    s.context,
+		Parent:     s.parent,
+		Start:      s.start,
+		End:        s.end,
+		Attributes: attrs,
+		Status:     s.status,
+		Message:    s.message,
+	}
+}
+
+// A tracer creates spans and provides them to an exporter once they end
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+// Start a span. If the context carries a span, the new span is its child;
+// otherwise, if a valid remote parent is provided, the new span continues
+// that trace. If neither is available, a new trace is started. The returned
+// context carries the new span.
+func (t *Tracer) Start(cxt context.Context, name string, kind SpanKind, remote SpanContext) (context.Context, *Span) {
+	parent := remote
+	if s := FromContext(cxt); s != nil {
+		parent = s.Context()
+	}
+	sc := SpanContext{SpanID: newSpanID()}
+	var pid SpanID
+	if parent.IsValid() {
+		sc.TraceID, sc.Flags, sc.State = parent.TraceID, parent.Flags, parent.State
+		pid = parent.SpanID
+	} else {
+		sc.TraceID, sc.Flags = newTraceID(), flagSampled
+	}
+	span := &Span{
+		tracer:  t,
+		name:    name,
+		kind:    kind,
+		context: sc,
+		parent:  pid,
+		start:   time.Now(),
+		attrs:   make(map[string]any),
+	}
+	return NewContext(cxt, span), span
+}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		Value   string
+		Trace   string
+		Span    string
+		Sampled bool
+		Error   error
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, nil},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false, nil},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, nil},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "", "", false, ErrMalformed},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "", false, ErrMalformed},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", "", false, ErrMalformed},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", "", "", false, ErrMalformed},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01", "", "", false, ErrMalformed},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", false, ErrInvalidID},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", false, ErrInvalidID},
+		{"", "", "", false, ErrMalformed},
+	}
+	for _, e := range tests {
+		c, err := ParseTraceparent(e.Value)
+		if e.Error != nil {
+			assert.ErrorIs(t, err, e.Error, e.Value)
+			continue
+		}
+		if assert.NoError(t, err, e.Value) {
+			assert.Equal(t, e.Trace, c.TraceID.String())
+			assert.Equal(t, e.Span, c.SpanID.String())
+			assert.Equal(t, e.Sampled, c.Sampled())
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	exp := NewMemoryExporter()
+	tr := NewTracer(exp)
+
+	h := make(http.Header)
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set(TracestateHeader, "vendor=value")
+	cxt, span := tr.Start(context.Background(), "parent", SpanKindServer, Extract(h))
+	_, child := tr.Start(cxt, "child", SpanKindInternal, SpanContext{})
+	assert.Equal(t, span.Context().TraceID, child.Context().TraceID)
+
+	out := make(http.Header)
+	Inject(cxt, out)
+	assert.Equal(t, span.Context().Traceparent(), out.Get(TraceparentHeader))
+	assert.Equal(t, "vendor=value", out.Get(TracestateHeader))
+
+	child.End()
+	span.End()
+	span.End() // no effect
+	spans := exp.Spans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "child", spans[0].Name)
+		assert.Equal(t, span.Context().SpanID, spans[0].Parent)
+		assert.Equal(t, "parent", spans[1].Name)
+		assert.Equal(t, "00f067aa0ba902b7", spans[1].Parent.String())
+	}
+
+	// unsampled traces are propagated but not exported
+	exp.Reset()
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span = tr.Start(context.Background(), "unsampled", SpanKindServer, Extract(h))
+	span.End()
+	assert.Len(t, exp.Spans(), 0)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var reqs []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var doc map[string]any
+		assert.NoError(t, json.Unmarshal(data, &doc))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		mu.Lock()
+		reqs = append(reqs, doc)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	exp := NewOTLPExporter(WithEndpoint(srv.URL), WithServiceName("test"), WithHeader("X-Api-Key", "secret"), WithInterval(time.Hour))
+	tr := NewTracer(exp)
+	_, span := tr.Start(context.Background(), "GET /a", SpanKindServer, SpanContext{})
+	span.SetAttr("http.response.status_code", 500).SetError(io.EOF)
+	span.End()
+
+	assert.NoError(t, exp.Shutdown(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	if !assert.Len(t, reqs, 1) {
+		return
+	}
+	rs := reqs[0]["resourceSpans"].([]any)[0].(map[string]any)
+	assert.Equal(t, map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "test"}}, rs["resource"].(map[string]any)["attributes"].([]any)[0])
+	s := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	assert.Equal(t, "GET /a", s["name"])
+	assert.Equal(t, span.Context().TraceID.String(), s["traceId"])
+	assert.Equal(t, span.Context().SpanID.String(), s["spanId"])
+	assert.Equal(t, float64(SpanKindServer), s["kind"])
+	assert.Equal(t, map[string]any{"code": float64(StatusError), "message": "EOF"}, s["status"])
+	assert.Contains(t, s["attributes"], map[string]any{"key": "http.response.status_code", "value": map[string]any{"intValue": "500"}})
+
+	// spans exported after shutdown are dropped
+	exp.Export(&SpanData{})
+}
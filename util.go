@@ -10,7 +10,11 @@ func isMimetypeBinary(t string) bool {
 	if err != nil {
 		return true
 	}
-	if m == "application/json" {
+	if m == "application/json" || m == "application/x-www-form-urlencoded" {
+		return false
+	} else if m == "application/xml" || m == "application/javascript" {
+		return false
+	} else if strings.HasSuffix(m, "+json") || strings.HasSuffix(m, "+xml") {
 		return false
 	} else if strings.HasPrefix(m, "text/") {
 		return false
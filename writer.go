@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"net/http"
+)
+
+// responseWriter wraps an http.ResponseWriter to record the status and the
+// number of bytes written in the response
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+// The status written, or zero if nothing has been written yet
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+// The number of entity bytes written
+func (w *responseWriter) Bytes() int64 {
+	return w.bytes
+}
+
+func (w *responseWriter) WriteHeader(s int) {
+	if w.status == 0 {
+		w.status = s
+	}
+	w.ResponseWriter.WriteHeader(s)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK // implicitly written by the underlying writer
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush conforms to http.Flusher if the underlying writer does; this is
+// required for streaming responses
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to access the underlying writer
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}